@@ -0,0 +1,171 @@
+package codenames
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Completed games are archived under the []byte(`/archive/`) key
+// prefix, grouped by room, so that they can be reviewed after they've
+// been replaced or have expired.
+const (
+	archivePrefix     = "/archive/"
+	archiveUpperBound = "/archive0"
+)
+
+func archiveRoomPrefix(roomID string) []byte {
+	return []byte(fmt.Sprintf("%s%q/", archivePrefix, roomID))
+}
+
+func archiveKey(roomID, archiveID string) []byte {
+	return append(archiveRoomPrefix(roomID), archiveID...)
+}
+
+// archiveID returns the ID of g within its room's archive. Game IDs are
+// reused by every game in a room, so games are distinguished by the
+// time they were created.
+func archiveID(g *Game) string {
+	return fmt.Sprintf("%019d", g.CreatedAt.UnixNano())
+}
+
+// Archive saves a completed game to the archive.
+func (ps *PebbleStore) Archive(g *Game) error {
+	_, v, err := gameKV(g)
+	if err != nil {
+		return fmt.Errorf("archive: %w", err)
+	}
+	err = ps.write(Change{Op: changeSet, Key: archiveKey(g.ID, archiveID(g)), Value: v})
+	if err != nil {
+		return fmt.Errorf("db.Set: %w", err)
+	}
+	return nil
+}
+
+// ArchivedGames returns summaries of the archived games of a room,
+// oldest first.
+func (ps *PebbleStore) ArchivedGames(roomID string) ([]GameSummary, error) {
+	prefix := archiveRoomPrefix(roomID)
+	iter := ps.DB.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: append(prefix[:len(prefix):len(prefix)], 0xFF),
+	})
+	defer iter.Close()
+
+	var summaries []GameSummary
+	for _ = iter.First(); iter.Valid(); iter.Next() {
+		g, _, err := decodeGame(iter.Value())
+		if err != nil {
+			return nil, fmt.Errorf("decoding archived game %s: %w", iter.Key(), err)
+		}
+		summaries = append(summaries, summarize(g))
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("archive iter: %w", err)
+	}
+	return summaries, nil
+}
+
+// ArchivedGame returns an archived game, or nil if it doesn't exist.
+func (ps *PebbleStore) ArchivedGame(roomID, archiveID string) (*Game, error) {
+	v, closer, err := ps.DB.Get(archiveKey(roomID, archiveID))
+	if err == pebble.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	g, _, err := decodeGame(v)
+	return g, err
+}
+
+// DeleteExpiredArchives deletes archived games that ended longer ago than
+// the retention policy's Archive duration. It returns the number of
+// games deleted.
+func (ps *PebbleStore) DeleteExpiredArchives(policy RetentionPolicy, now time.Time) (int, error) {
+	if policy.Archive <= 0 {
+		return 0, nil
+	}
+	iter := ps.DB.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(archivePrefix),
+		UpperBound: []byte(archiveUpperBound),
+	})
+	defer iter.Close()
+
+	var deletes []Change
+	for _ = iter.First(); iter.Valid(); iter.Next() {
+		g, _, err := decodeGame(iter.Value())
+		if err != nil || g.UpdatedAt.Add(policy.Archive).Before(now) {
+			deletes = append(deletes, Change{
+				Op:  changeDelete,
+				Key: append([]byte(nil), iter.Key()...),
+			})
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return 0, fmt.Errorf("archive iter: %w", err)
+	}
+	return len(deletes), ps.write(deletes...)
+}
+
+type historyResponse struct {
+	RoomID string        `json:"room_id"`
+	Games  []GameSummary `json:"games"`
+}
+
+// GET /history?room_id=<room id>
+//
+// Lists a room's archived games, most recent first.
+func (s *Server) handleHistory(rw http.ResponseWriter, req *http.Request) {
+	roomID := req.URL.Query().Get("room_id")
+	summaries, err := s.Store.ArchivedGames(roomID)
+	if err != nil {
+		http.Error(rw, "unable to read archive: "+err.Error(), 500)
+		return
+	}
+	for i, j := 0, len(summaries)-1; i < j; i, j = i+1, j-1 {
+		summaries[i], summaries[j] = summaries[j], summaries[i]
+	}
+	writeJSON(rw, historyResponse{RoomID: roomID, Games: summaries})
+}
+
+// GET /history/game?room_id=<room id>&archive_id=<archive id>
+//
+// Returns an archived game in full, including its layout and the order
+// in which words were revealed.
+func (s *Server) handleHistoryGame(rw http.ResponseWriter, req *http.Request) {
+	g, ok := s.archivedGame(rw, req)
+	if !ok {
+		return
+	}
+	writeJSON(rw, archivedGameResponse{Game: g, ArchiveID: archiveID(g)})
+}
+
+// archivedGame reads the archived game identified by the room_id and
+// archive_id query parameters. If it can't, it writes an error to rw
+// and returns false.
+func (s *Server) archivedGame(rw http.ResponseWriter, req *http.Request) (*Game, bool) {
+	q := req.URL.Query()
+	if _, err := strconv.ParseInt(q.Get("archive_id"), 10, 64); err != nil {
+		http.Error(rw, "invalid archive_id", 400)
+		return nil, false
+	}
+	g, err := s.Store.ArchivedGame(q.Get("room_id"), q.Get("archive_id"))
+	if err != nil {
+		http.Error(rw, "unable to read archive: "+err.Error(), 500)
+		return nil, false
+	}
+	if g == nil {
+		http.NotFound(rw, req)
+		return nil, false
+	}
+	return g, true
+}
+
+type archivedGameResponse struct {
+	*Game
+	ArchiveID string `json:"archive_id"`
+}
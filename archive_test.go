@@ -0,0 +1,100 @@
+package codenames
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestArchiveCompletedGames(t *testing.T) {
+	ps := openTestStore(t, "test-archive-*")
+	defer ps.DB.Close()
+	s := newTestServer(ps)
+
+	// Play two games to completion, replacing the first.
+	var revealOrder []int
+	for i := 0; i < 2; i++ {
+		gh := s.getGame("review")
+		var g Game
+		if i == 0 {
+			do(t, s.handleClue, "POST", map[string]interface{}{"game_id": "review", "word": "zyzzyva", "number": 9}, nil)
+		}
+		for idx, team := range gh.g.Layout {
+			if team != Red {
+				continue
+			}
+			do(t, s.handleGuess, "POST", map[string]interface{}{"game_id": "review", "index": idx}, &g)
+			if i == 0 {
+				revealOrder = append(revealOrder, idx)
+			}
+		}
+		if g.WinningTeam == nil {
+			t.Fatal("game didn't end")
+		}
+		if code := do(t, s.handleGuess, "POST", map[string]interface{}{"game_id": "review", "index": guessable(&g, Blue)}, nil); code != 400 {
+			t.Errorf("guessing after the game ended: status %d, expected 400", code)
+		}
+		if i == 0 {
+			do(t, s.handleNextGame, "POST", map[string]interface{}{"game_id": "review", "create_new": true}, nil)
+		}
+	}
+
+	var history historyResponse
+	req := httptest.NewRequest("GET", "/history?room_id=review", nil)
+	rec := httptest.NewRecorder()
+	s.handleHistory(rec, req)
+	decodeRecorder(t, rec, &history)
+	if len(history.Games) != 2 {
+		t.Fatalf("expected 2 archived games, got %d", len(history.Games))
+	}
+	oldest := history.Games[1]
+	if oldest.WinningTeam == nil || *oldest.WinningTeam != Red || oldest.ArchiveID == "" {
+		t.Fatalf("unexpected summary: %+v", oldest)
+	}
+
+	var archived Game
+	req = httptest.NewRequest("GET", "/history/game?room_id=review&archive_id="+oldest.ArchiveID, nil)
+	rec = httptest.NewRecorder()
+	s.handleHistoryGame(rec, req)
+	decodeRecorder(t, rec, &archived)
+	if len(archived.Actions) != len(revealOrder)+1 {
+		t.Fatalf("expected a clue and %d guesses, got %d actions", len(revealOrder), len(archived.Actions))
+	}
+	if clue := archived.Actions[0]; clue.Type != ActionClue || clue.Word != "zyzzyva" || clue.Number != 9 {
+		t.Errorf("first action is %+v, expected the clue", clue)
+	}
+	for i, a := range archived.Actions[1:] {
+		if a.Type != ActionGuess || a.Index != revealOrder[i] {
+			t.Errorf("action %d is %+v, expected a guess of %d", i, a, revealOrder[i])
+		}
+	}
+
+	// Archived games replay the same way every time.
+	replay := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleHistoryReplay(rec, httptest.NewRequest("GET", "/history/replay?"+query, nil))
+		return rec
+	}
+	first := replay("room_id=review&archive_id=" + oldest.ArchiveID)
+	if first.Code != 200 || first.Body.String() != replay("room_id=review&archive_id="+oldest.ArchiveID).Body.String() {
+		t.Errorf("replaying an archived game: status %d, or it differs between replays", first.Code)
+	}
+	for _, handler := range []http.HandlerFunc{s.handleHistoryGame, s.handleHistoryReplay} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/?room_id=review&archive_id=latest", nil))
+		if rec.Code != 400 {
+			t.Errorf("invalid archive_id: status %d, expected 400", rec.Code)
+		}
+	}
+
+	// Archives outlive the games themselves, but not forever.
+	n, err := ps.DeleteExpiredArchives(DefaultRetentionPolicy, time.Now().Add(24*time.Hour))
+	if err != nil || n != 0 {
+		t.Errorf("deleted %d archived games early (err %v)", n, err)
+	}
+	n, err = ps.DeleteExpiredArchives(DefaultRetentionPolicy, time.Now().Add(DefaultRetentionPolicy.Archive+time.Hour))
+	if err != nil || n != 2 {
+		t.Errorf("deleted %d expired archived games, expected 2 (err %v)", n, err)
+	}
+}
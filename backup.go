@@ -0,0 +1,221 @@
+package codenames
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// BackupCounts are the numbers of each kind of record in a backup.
+type BackupCounts struct {
+	Games         int
+	Rooms         int
+	ArchivedGames int
+	WordPacks     int
+}
+
+func (c BackupCounts) String() string {
+	return fmt.Sprintf("%d games, %d rooms, %d archived games and %d word packs",
+		c.Games, c.Rooms, c.ArchivedGames, c.WordPacks)
+}
+
+// backupRecord wraps the records of a backup other than games, which
+// are written bare so that backups of games alone remain readable.
+// Exactly one field is set.
+type backupRecord struct {
+	Room         json.RawMessage `json:"room,omitempty"`
+	ArchivedGame json.RawMessage `json:"archived_game,omitempty"`
+	WordPack     json.RawMessage `json:"word_pack,omitempty"`
+}
+
+// Export writes every persisted game, room, archived game and word pack
+// created within [createdAfter, createdBefore) to w as JSON lines, in
+// the same representations stored on disk. A zero createdBefore doesn't
+// bound the export.
+func (ps *PebbleStore) Export(w io.Writer, createdAfter, createdBefore time.Time) (BackupCounts, error) {
+	var counts BackupCounts
+	inRange := func(t time.Time) bool {
+		return !t.Before(createdAfter) && (createdBefore.IsZero() || t.Before(createdBefore))
+	}
+	writeLine := func(v []byte) error {
+		if _, err := w.Write(v); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	}
+	writeRecord := func(r backupRecord) error {
+		v, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return writeLine(v)
+	}
+
+	upper := []byte(fmt.Sprintf("/games/%019d", math.MaxInt64))
+	if !createdBefore.IsZero() {
+		// Keys only have second granularity; the exact bound is
+		// checked against each decoded game below.
+		upper = mkkey(createdBefore.Unix()+1, "")
+	}
+	lower := mkkey(0, "")
+	if createdAfter.Unix() > 0 {
+		lower = mkkey(createdAfter.Unix(), "")
+	}
+	err := ps.scan(lower, upper, func(k, v []byte) error {
+		g, _, err := decodeGame(v)
+		if err != nil {
+			log.Printf("Skipping undecodable game %s: %s\n", k, err)
+			return nil
+		}
+		if !inRange(g.CreatedAt) {
+			return nil
+		}
+		counts.Games++
+		return writeLine(v)
+	})
+	if err != nil {
+		return counts, fmt.Errorf("exporting games: %w", err)
+	}
+
+	err = ps.scan([]byte(roomsPrefix), []byte(roomsUpperBound), func(k, v []byte) error {
+		var r Room
+		if err := json.Unmarshal(v, &r); err != nil {
+			log.Printf("Skipping undecodable room %s: %s\n", k, err)
+			return nil
+		}
+		if !inRange(r.CreatedAt) {
+			return nil
+		}
+		counts.Rooms++
+		return writeRecord(backupRecord{Room: v})
+	})
+	if err != nil {
+		return counts, fmt.Errorf("exporting rooms: %w", err)
+	}
+
+	err = ps.scan([]byte(archivePrefix), []byte(archiveUpperBound), func(k, v []byte) error {
+		g, _, err := decodeGame(v)
+		if err != nil {
+			log.Printf("Skipping undecodable archived game %s: %s\n", k, err)
+			return nil
+		}
+		if !inRange(g.CreatedAt) {
+			return nil
+		}
+		counts.ArchivedGames++
+		return writeRecord(backupRecord{ArchivedGame: v})
+	})
+	if err != nil {
+		return counts, fmt.Errorf("exporting archived games: %w", err)
+	}
+
+	err = ps.scan([]byte(packsPrefix), []byte(packsUpperBound), func(k, v []byte) error {
+		var p WordPack
+		if err := json.Unmarshal(v, &p); err != nil {
+			log.Printf("Skipping undecodable word pack %s: %s\n", k, err)
+			return nil
+		}
+		if !inRange(p.CreatedAt) {
+			return nil
+		}
+		counts.WordPacks++
+		return writeRecord(backupRecord{WordPack: v})
+	})
+	if err != nil {
+		return counts, fmt.Errorf("exporting word packs: %w", err)
+	}
+	return counts, nil
+}
+
+// scan calls fn with each key and value in [lower, upper).
+func (ps *PebbleStore) scan(lower, upper []byte, fn func(k, v []byte) error) error {
+	iter := ps.DB.NewIter(&pebble.IterOptions{
+		LowerBound: lower,
+		UpperBound: upper,
+	})
+	defer iter.Close()
+	for _ = iter.First(); iter.Valid(); iter.Next() {
+		if err := fn(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// Import reads records written by Export from r, upgrading games to the
+// current schema version, and saves them.
+func (ps *PebbleStore) Import(r io.Reader) (BackupCounts, error) {
+	var counts BackupCounts
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			return counts, nil
+		} else if err != nil {
+			return counts, fmt.Errorf("reading record %d: %w", line, err)
+		}
+		if err := ps.importRecord(raw, &counts); err != nil {
+			return counts, fmt.Errorf("record %d: %w", line, err)
+		}
+	}
+}
+
+func (ps *PebbleStore) importRecord(raw json.RawMessage, counts *BackupCounts) error {
+	var rec backupRecord
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return fmt.Errorf("decoding: %w", err)
+	}
+	switch {
+	case rec.Room != nil:
+		var r Room
+		if err := json.Unmarshal(rec.Room, &r); err != nil {
+			return fmt.Errorf("decoding room: %w", err)
+		}
+		if r.ID == "" {
+			return errors.New("room has no ID")
+		}
+		if err := ps.SaveRoom(&r); err != nil {
+			return err
+		}
+		counts.Rooms++
+	case rec.ArchivedGame != nil:
+		g, _, err := decodeGame(rec.ArchivedGame)
+		if err != nil {
+			return fmt.Errorf("decoding archived game: %w", err)
+		}
+		if err := ps.Archive(g); err != nil {
+			return err
+		}
+		counts.ArchivedGames++
+	case rec.WordPack != nil:
+		var p WordPack
+		if err := json.Unmarshal(rec.WordPack, &p); err != nil {
+			return fmt.Errorf("decoding word pack: %w", err)
+		}
+		if p.ID == "" {
+			return errors.New("word pack has no ID")
+		}
+		if err := ps.SaveWordPack(&p); err != nil {
+			return err
+		}
+		counts.WordPacks++
+	default:
+		g, _, err := decodeGame(raw)
+		if err != nil {
+			return fmt.Errorf("decoding game: %w", err)
+		}
+		if err := ps.Save(g); err != nil {
+			return err
+		}
+		counts.Games++
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package codenames
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// boardCodeVersion is the first byte of every encoded board code, so the
+// format can change without misinterpreting old codes.
+const boardCodeVersion = 1
+
+// boardCodeIDLen is the number of bytes of the word set ID included in a
+// board code. Word sets are looked up by this prefix of their ID.
+const boardCodeIDLen = 8
+
+// BoardCode is a compact, shareable description of a board. Any server
+// that knows the board's word set can recreate the exact same words,
+// layout and starting team from it, since newGame is deterministic.
+type BoardCode struct {
+	Seed      int64
+	PermIndex int
+	WordSetID [boardCodeIDLen]byte
+	GameOptions
+}
+
+// newBoardCode returns the board code for a game whose word set has
+// the given ID.
+func newBoardCode(g *Game, id wordSetID) BoardCode {
+	bc := BoardCode{
+		Seed:        g.Seed,
+		PermIndex:   g.PermIndex,
+		GameOptions: g.GameOptions,
+	}
+	copy(bc.WordSetID[:], id[:])
+	return bc
+}
+
+// String encodes the board code in URL-safe base64.
+func (bc BoardCode) String() string {
+	b := make([]byte, 0, 1+3*binary.MaxVarintLen64+boardCodeIDLen+1)
+	var tmp [binary.MaxVarintLen64]byte
+	b = append(b, boardCodeVersion)
+	b = append(b, tmp[:binary.PutVarint(tmp[:], bc.Seed)]...)
+	b = append(b, tmp[:binary.PutUvarint(tmp[:], uint64(bc.PermIndex))]...)
+	b = append(b, bc.WordSetID[:]...)
+	b = append(b, tmp[:binary.PutUvarint(tmp[:], uint64(bc.TimerDurationMS))]...)
+	var flags byte
+	if bc.EnforceTimer {
+		flags |= 1
+	}
+	b = append(b, flags)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseBoardCode decodes a board code produced by BoardCode.String.
+func ParseBoardCode(s string) (BoardCode, error) {
+	var bc BoardCode
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return bc, errors.New("malformed board code")
+	}
+	if len(b) == 0 || b[0] != boardCodeVersion {
+		return bc, errors.New("unsupported board code version")
+	}
+	b = b[1:]
+
+	var n int
+	bc.Seed, n = binary.Varint(b)
+	if n <= 0 {
+		return bc, errors.New("malformed board code seed")
+	}
+	b = b[n:]
+	permIndex, n := binary.Uvarint(b)
+	if n <= 0 || permIndex > 1<<31 {
+		return bc, errors.New("malformed board code permutation index")
+	}
+	bc.PermIndex = int(permIndex)
+	b = b[n:]
+	if len(b) < boardCodeIDLen {
+		return bc, errors.New("malformed board code word set")
+	}
+	copy(bc.WordSetID[:], b)
+	b = b[boardCodeIDLen:]
+	timer, n := binary.Uvarint(b)
+	if n <= 0 || timer > 1<<53 {
+		return bc, errors.New("malformed board code timer")
+	}
+	bc.TimerDurationMS = int64(timer)
+	b = b[n:]
+	if len(b) != 1 {
+		return bc, fmt.Errorf("malformed board code options")
+	}
+	bc.EnforceTimer = b[0]&1 != 0
+	return bc, nil
+}
@@ -0,0 +1,82 @@
+package codenames
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBoardCodeRoundTrip(t *testing.T) {
+	for _, bc := range []BoardCode{
+		{},
+		{Seed: -1, PermIndex: 375, WordSetID: [boardCodeIDLen]byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{Seed: 1<<62 + 7, PermIndex: 25, GameOptions: GameOptions{TimerDurationMS: 90000, EnforceTimer: true}},
+	} {
+		parsed, err := ParseBoardCode(bc.String())
+		if err != nil {
+			t.Fatalf("ParseBoardCode(%q): %s", bc.String(), err)
+		}
+		if parsed != bc {
+			t.Errorf("ParseBoardCode(%q) = %+v, expected %+v", bc.String(), parsed, bc)
+		}
+	}
+
+	for _, s := range []string{"", "!!", "AA", truncatedBoardCode()} {
+		if _, err := ParseBoardCode(s); err == nil {
+			t.Errorf("ParseBoardCode(%q) succeeded, expected an error", s)
+		}
+	}
+}
+
+func truncatedBoardCode() string {
+	s := BoardCode{Seed: 12345}.String()
+	return s[:len(s)-3]
+}
+
+func TestBoardCodeSharesBoard(t *testing.T) {
+	s := newTestServer(nil)
+
+	var original Game
+	code := do(t, s.handleNextGame, "POST", map[string]interface{}{
+		"game_id":           "room-a",
+		"word_set":          testWords[:100],
+		"timer_duration_ms": 60000,
+	}, &original)
+	if code != 200 {
+		t.Fatalf("creating game: status %d", code)
+	}
+	if original.BoardCode == "" {
+		t.Fatal("game has no board code")
+	}
+
+	var shared Game
+	code = do(t, s.handleNextGame, "POST", map[string]interface{}{
+		"game_id":    "room-b",
+		"board_code": original.BoardCode,
+	}, &shared)
+	if code != 200 {
+		t.Fatalf("creating game from board code: status %d", code)
+	}
+	if !reflect.DeepEqual(shared.Words, original.Words) {
+		t.Errorf("shared board has words %v, expected %v", shared.Words, original.Words)
+	}
+	if !reflect.DeepEqual(shared.Layout, original.Layout) {
+		t.Errorf("shared board has layout %v, expected %v", shared.Layout, original.Layout)
+	}
+	if shared.StartingTeam != original.StartingTeam {
+		t.Errorf("shared board starts with %v, expected %v", shared.StartingTeam, original.StartingTeam)
+	}
+	if shared.TimerDurationMS != 60000 {
+		t.Errorf("shared board has timer %d, expected 60000", shared.TimerDurationMS)
+	}
+	if shared.BoardCode != original.BoardCode {
+		t.Errorf("shared board has code %q, expected %q", shared.BoardCode, original.BoardCode)
+	}
+
+	code = do(t, s.handleNextGame, "POST", map[string]interface{}{
+		"game_id":    "room-c",
+		"board_code": BoardCode{Seed: 1}.String(),
+	}, nil)
+	if code != 400 {
+		t.Errorf("unknown word set: status %d, expected 400", code)
+	}
+}
@@ -0,0 +1,99 @@
+package codenames
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// parseLanguage parses the BCP 47 language tag of a word set. An empty
+// tag selects the language-neutral root locale.
+func parseLanguage(lang string) (language.Tag, error) {
+	if lang == "" {
+		return language.Und, nil
+	}
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return language.Und, fmt.Errorf("invalid language %q", lang)
+	}
+	return tag, nil
+}
+
+// canonicalWords returns the distinct canonical forms of words, sorted,
+// omitting any that are empty once canonicalized. Words are upper-cased
+// using the case mapping of the language lang, so that, for example,
+// Turkish "i" becomes "İ" and German "ß" becomes "SS".
+func canonicalWords(words []string, lang string) ([]string, error) {
+	tag, err := parseLanguage(lang)
+	if err != nil {
+		return nil, err
+	}
+	// Casers are stateful, so each call gets its own.
+	upper := cases.Upper(tag)
+
+	set := map[string]bool{}
+	for _, w := range words {
+		if w = canonicalWord(w, upper); w != "" {
+			set[w] = true
+		}
+	}
+	canonical := make([]string, 0, len(set))
+	for w := range set {
+		canonical = append(canonical, w)
+	}
+	sort.Strings(canonical)
+	return canonical, nil
+}
+
+// invisible holds the format characters removed from words: the byte
+// order mark, soft hyphens, zero-width spaces and word joiners, and
+// direction marks, embeddings and isolates. Other format characters,
+// such as the zero-width joiner and non-joiner, change how words are
+// written in some scripts, so they're kept.
+var invisible = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00ad, Hi: 0x00ad, Stride: 1},
+		{Lo: 0x061c, Hi: 0x061c, Stride: 1},
+		{Lo: 0x200b, Hi: 0x200b, Stride: 1},
+		{Lo: 0x200e, Hi: 0x200f, Stride: 1},
+		{Lo: 0x202a, Hi: 0x202e, Stride: 1},
+		{Lo: 0x2060, Hi: 0x2060, Stride: 1},
+		{Lo: 0x2066, Hi: 0x2069, Stride: 1},
+		{Lo: 0xfeff, Hi: 0xfeff, Stride: 1},
+	},
+}
+
+// canonicalWord returns the canonical form of w: control and invisible
+// characters, such as stray direction marks and zero-width spaces, are
+// removed, runs
+// of whitespace are collapsed to a single space, and the word is
+// upper-cased with upper and put in Unicode normalization form C, so
+// that precomposed and combining accents compare equal.
+func canonicalWord(w string, upper cases.Caser) string {
+	w = strings.Map(func(r rune) rune {
+		if unicode.In(r, unicode.Cc, invisible) && !unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, w)
+	w = norm.NFC.String(strings.Join(strings.Fields(w), " "))
+
+	// Some case mappings aren't idempotent: Greek upper-casing drops
+	// accents depending on the letters around them, which may only be
+	// decided on a second pass. Canonical forms must be stable, so map
+	// until the word stops changing.
+	for i := 0; i < 3; i++ {
+		upper.Reset()
+		u := norm.NFC.String(upper.String(w))
+		if u == w {
+			break
+		}
+		w = u
+	}
+	return w
+}
@@ -0,0 +1,240 @@
+package codenames
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Operations recorded in the change feed.
+const (
+	changeSet         = "set"
+	changeDelete      = "delete"
+	changeDeleteRange = "delete_range"
+)
+
+// ErrChangesTruncated is returned by Changes when changes following the
+// requested sequence number have already been trimmed from the feed. The
+// follower must bootstrap from a fresh checkpoint.
+var ErrChangesTruncated = errors.New("change feed truncated")
+
+// ErrChangesAhead is returned by Changes when the requested sequence
+// number is beyond the most recent change, which means the follower has
+// diverged from the feed, for example because the primary was restored
+// from a backup. The follower must bootstrap from a fresh checkpoint.
+var ErrChangesAhead = errors.New("sequence number is ahead of the change feed")
+
+// Change is a single mutation of the store, recorded in the change feed
+// under a strictly increasing sequence number so that followers can
+// replicate it.
+type Change struct {
+	Seq   uint64    `json:"seq"`
+	At    time.Time `json:"at"`
+	Op    string    `json:"op"`
+	Key   []byte    `json:"key"`
+	End   []byte    `json:"end,omitempty"`
+	Value []byte    `json:"value,omitempty"`
+}
+
+var seqKey = []byte("/meta/seq")
+
+const (
+	changesPrefix     = "/changes/"
+	changesUpperBound = "/changes0"
+)
+
+func changeKey(seq uint64) []byte {
+	return []byte(fmt.Sprintf("%s%020d", changesPrefix, seq))
+}
+
+// loadSeq reads the last recorded sequence number from disk the first
+// time it's called. ps.mu must be held.
+func (ps *PebbleStore) loadSeq() error {
+	if ps.changed != nil {
+		return nil
+	}
+	v, closer, err := ps.DB.Get(seqKey)
+	if err == pebble.ErrNotFound {
+		ps.seq = 0
+	} else if err != nil {
+		return err
+	} else {
+		ps.seq, err = strconv.ParseUint(string(v), 10, 64)
+		closer.Close()
+		if err != nil {
+			return fmt.Errorf("parsing sequence number: %w", err)
+		}
+	}
+	ps.changed = make(chan struct{})
+	return nil
+}
+
+// write atomically applies ops to the DB, assigning each of them the
+// next sequence number and recording it in the change feed.
+func (ps *PebbleStore) write(ops ...Change) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if err := ps.loadSeq(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range ops {
+		ops[i].Seq = ps.seq + uint64(i) + 1
+		ops[i].At = now
+	}
+	return ps.commitChanges(ops)
+}
+
+// ApplyChanges applies changes read from another store's change feed,
+// recording them in this store's feed under the same sequence numbers.
+// The changes must directly follow LastSeq.
+func (ps *PebbleStore) ApplyChanges(changes []Change) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if err := ps.loadSeq(); err != nil {
+		return err
+	}
+	for i, c := range changes {
+		if want := ps.seq + uint64(i) + 1; c.Seq != want {
+			return fmt.Errorf("change has sequence number %d, expected %d", c.Seq, want)
+		}
+	}
+	return ps.commitChanges(changes)
+}
+
+// commitChanges commits changes to the DB in a single batch and wakes
+// anyone waiting on the change feed. ps.mu must be held.
+func (ps *PebbleStore) commitChanges(changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	b := ps.DB.NewBatch()
+	defer b.Close()
+
+	for _, c := range changes {
+		var err error
+		switch c.Op {
+		case changeSet:
+			err = b.Set(c.Key, c.Value, nil)
+		case changeDelete:
+			err = b.Delete(c.Key, nil)
+		case changeDeleteRange:
+			err = b.DeleteRange(c.Key, c.End, nil)
+		default:
+			err = fmt.Errorf("unknown change operation %q", c.Op)
+		}
+		if err != nil {
+			return err
+		}
+
+		v, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if err := b.Set(changeKey(c.Seq), v, nil); err != nil {
+			return err
+		}
+	}
+	last := changes[len(changes)-1].Seq
+	if err := b.Set(seqKey, []byte(strconv.FormatUint(last, 10)), nil); err != nil {
+		return err
+	}
+	if err := b.Commit(&pebble.WriteOptions{Sync: true}); err != nil {
+		return err
+	}
+
+	ps.seq = last
+	close(ps.changed)
+	ps.changed = make(chan struct{})
+	return nil
+}
+
+// LastSeq returns the sequence number of the most recent change.
+func (ps *PebbleStore) LastSeq() (uint64, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if err := ps.loadSeq(); err != nil {
+		return 0, err
+	}
+	return ps.seq, nil
+}
+
+// Changes returns up to limit changes with sequence numbers greater than
+// since, along with a channel that's closed when a newer change is
+// recorded.
+func (ps *PebbleStore) Changes(since uint64, limit int) ([]Change, <-chan struct{}, error) {
+	ps.mu.Lock()
+	if err := ps.loadSeq(); err != nil {
+		ps.mu.Unlock()
+		return nil, nil, err
+	}
+	last, changed := ps.seq, ps.changed
+	ps.mu.Unlock()
+
+	if since > last {
+		return nil, nil, ErrChangesAhead
+	}
+	if since == last {
+		return nil, changed, nil
+	}
+
+	iter := ps.DB.NewIter(&pebble.IterOptions{
+		LowerBound: changeKey(since + 1),
+		UpperBound: []byte(changesUpperBound),
+	})
+	defer iter.Close()
+
+	var changes []Change
+	for _ = iter.First(); iter.Valid() && len(changes) < limit; iter.Next() {
+		var c Change
+		if err := json.Unmarshal(iter.Value(), &c); err != nil {
+			return nil, nil, fmt.Errorf("Unmarshal change %s: %w", iter.Key(), err)
+		}
+		if c.Seq > last {
+			break
+		}
+		changes = append(changes, c)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, nil, fmt.Errorf("changes iter: %w", err)
+	}
+	if len(changes) == 0 || changes[0].Seq != since+1 {
+		return nil, nil, ErrChangesTruncated
+	}
+	return changes, changed, nil
+}
+
+// TrimChanges removes changes recorded before `before` from the feed.
+// Followers that haven't yet replicated them will need to bootstrap
+// again.
+func (ps *PebbleStore) TrimChanges(before time.Time) error {
+	iter := ps.DB.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(changesPrefix),
+		UpperBound: []byte(changesUpperBound),
+	})
+	defer iter.Close()
+
+	var end []byte
+	for _ = iter.First(); iter.Valid(); iter.Next() {
+		var c Change
+		if err := json.Unmarshal(iter.Value(), &c); err != nil {
+			return fmt.Errorf("Unmarshal change %s: %w", iter.Key(), err)
+		}
+		if !c.At.Before(before) {
+			break
+		}
+		end = changeKey(c.Seq + 1)
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("changes iter: %w", err)
+	}
+	if end == nil {
+		return nil
+	}
+	return ps.DB.DeleteRange([]byte(changesPrefix), end, nil)
+}
@@ -0,0 +1,157 @@
+package codenames
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+func openTestStore(t *testing.T, pattern string) *PebbleStore {
+	dir, err := ioutil.TempDir("", pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := pebble.Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &PebbleStore{DB: db}
+}
+
+func TestChangeFeedReplication(t *testing.T) {
+	primary := openTestStore(t, "test-primary-*")
+	defer primary.DB.Close()
+	follower := openTestStore(t, "test-follower-*")
+	defer follower.DB.Close()
+
+	games := randomGames(6)
+	for _, g := range games {
+		if err := primary.Save(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var deleted string
+	for id, g := range games {
+		if err := primary.Delete(g); err != nil {
+			t.Fatal(err)
+		}
+		deleted = id
+		break
+	}
+
+	// Replicate in small batches, as a follower would.
+	var since uint64
+	for {
+		changes, changed, err := replicate(follower, primary, since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) == 0 {
+			// Caught up; the channel should fire on the next write.
+			select {
+			case <-changed:
+				t.Fatal("change channel closed without a new change")
+			default:
+			}
+			break
+		}
+		since = changes[len(changes)-1].Seq
+	}
+
+	want, err := primary.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := follower.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) || len(got) != len(games)-1 {
+		t.Fatalf("follower has %d games, primary has %d", len(got), len(want))
+	}
+	if _, ok := got[deleted]; ok {
+		t.Errorf("deleted game %q was replicated", deleted)
+	}
+	primarySeq, _ := primary.LastSeq()
+	followerSeq, _ := follower.LastSeq()
+	if primarySeq != followerSeq {
+		t.Errorf("follower at seq %d, primary at seq %d", followerSeq, primarySeq)
+	}
+
+	// Applying a change out of order must fail.
+	changes, _, err := primary.Changes(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := follower.ApplyChanges(changes); err == nil {
+		t.Error("expected an error applying an old change")
+	}
+
+	// Once trimmed, a follower that's behind must be told to bootstrap.
+	if err := primary.TrimChanges(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := primary.Changes(0, 10); err != ErrChangesTruncated {
+		t.Errorf("expected ErrChangesTruncated, got %v", err)
+	}
+	if changes, _, err := primary.Changes(primarySeq, 10); err != nil || len(changes) != 0 {
+		t.Errorf("expected no changes for an up-to-date follower, got %d, %v", len(changes), err)
+	}
+	if _, _, err := primary.Changes(primarySeq+1, 10); err != ErrChangesAhead {
+		t.Errorf("expected ErrChangesAhead for a follower ahead of the feed, got %v", err)
+	}
+
+	// Followers trim their own feeds too.
+	if err := follower.TrimChanges(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := follower.Changes(0, 10); err != ErrChangesTruncated {
+		t.Errorf("expected the follower's feed to be trimmed, got %v", err)
+	}
+	if seq, _ := follower.LastSeq(); seq != primarySeq {
+		t.Errorf("trimming moved the follower to seq %d", seq)
+	}
+}
+
+func TestChangesGone(t *testing.T) {
+	ps := openTestStore(t, "test-changes-gone-*")
+	defer ps.DB.Close()
+	for _, g := range randomGames(2) {
+		if err := ps.Save(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last, _ := ps.LastSeq()
+	s := newTestServer(ps)
+
+	// A follower ahead of the feed, such as one of a primary that was
+	// restored from a backup, must bootstrap again rather than retry.
+	rec := httptest.NewRecorder()
+	s.handleChanges(rec, httptest.NewRequest("GET", fmt.Sprintf("/changes?since=%d", last+10), nil))
+	if rec.Code != 410 {
+		t.Errorf("status %d, expected 410", rec.Code)
+	}
+
+	if err := ps.TrimChanges(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	s.handleChanges(rec, httptest.NewRequest("GET", "/changes?since=0", nil))
+	if rec.Code != 410 {
+		t.Errorf("status %d for a trimmed feed, expected 410", rec.Code)
+	}
+}
+
+// replicate applies a small batch of the primary's changes following
+// since to the follower.
+func replicate(follower, primary *PebbleStore, since uint64) ([]Change, <-chan struct{}, error) {
+	changes, changed, err := primary.Changes(since, 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	return changes, changed, follower.ApplyChanges(changes)
+}
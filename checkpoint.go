@@ -0,0 +1,342 @@
+package codenames
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkpointChunkSize is the maximum number of bytes of file contents
+// carried by a single CheckpointFrame, bounding the memory used on both
+// ends of a transfer regardless of the size of the database.
+const checkpointChunkSize = 1 << 20
+
+// partialSuffix is appended to the names of files that are still being
+// received.
+const partialSuffix = ".partial"
+
+// CheckpointFrame is a single message in the gzipped gob stream written
+// by Checkpoint.
+//
+// Each file is sent as a sequence of frames carrying Data, followed by a
+// frame with Done set carrying the file's size and SHA-256. If the
+// receiver already holds an identical copy of the file, the data frames
+// are omitted and the final frame has Reused set. The stream is
+// terminated by a frame carrying the Manifest of every file in the
+// checkpoint; a stream without a manifest is incomplete.
+type CheckpointFrame struct {
+	Name     string
+	Data     []byte
+	Done     bool
+	Reused   bool
+	Size     int64
+	SHA256   []byte
+	Manifest []CheckpointManifestEntry
+}
+
+// CheckpointManifestEntry describes a single file in a checkpoint.
+type CheckpointManifestEntry struct {
+	Name   string
+	Size   int64
+	SHA256 []byte
+}
+
+// Checkpoint writes a streaming, verifiable representation of the entire
+// store to w. The have map holds the hex-encoded SHA-256 of files, keyed
+// by name, that the receiver already holds from an earlier, interrupted
+// transfer. Those files are not resent if they're unchanged.
+func (ps *PebbleStore) Checkpoint(w io.Writer, have map[string]string) error {
+	// Compact the entire key space. The database tends to be small and there
+	// tends to be a significant number of obsolete keys, so this shouldn't be
+	// too expensive but will reduce the number of bytes we need to send over
+	// the network.
+	err := ps.DB.Compact([]byte{}, []byte{0xFF, 0xFF, 0xFF, 0xFF})
+	if err != nil {
+		return err
+	}
+
+	// Create a Pebble checkpoint in a temporary directory.
+	name, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(name); err != nil {
+		return err
+	}
+	defer os.RemoveAll(name)
+
+	err = ps.DB.Checkpoint(name)
+	if err != nil {
+		return err
+	}
+
+	// Stream all the files in the checkpoint out over the network.
+	gzipWriter := gzip.NewWriter(w)
+	enc := gob.NewEncoder(gzipWriter)
+	var manifest []CheckpointManifestEntry
+	err = filepath.Walk(name, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(name, path)
+		if err != nil {
+			return err
+		}
+		entry, err := sendCheckpointFile(enc, path, relPath, have[relPath])
+		if err != nil {
+			return err
+		}
+		manifest = append(manifest, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := enc.Encode(CheckpointFrame{Manifest: manifest}); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// sendCheckpointFile writes the file at path to enc in chunks. If the
+// receiver's copy has the hex-encoded SHA-256 haveSum, only the
+// trailing frame is sent.
+func sendCheckpointFile(enc *gob.Encoder, path, name, haveSum string) (CheckpointManifestEntry, error) {
+	entry := CheckpointManifestEntry{Name: name}
+	f, err := os.Open(path)
+	if err != nil {
+		return entry, err
+	}
+	defer f.Close()
+
+	if haveSum != "" {
+		sum, size, err := hashReader(f)
+		if err != nil {
+			return entry, err
+		}
+		entry.SHA256, entry.Size = sum, size
+		if hex.EncodeToString(sum) == haveSum {
+			log.Printf("Checkpoint reusing file %s (%d bytes)\n", name, size)
+			return entry, enc.Encode(CheckpointFrame{
+				Name:   name,
+				Done:   true,
+				Reused: true,
+				Size:   size,
+				SHA256: sum,
+			})
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return entry, err
+		}
+	}
+
+	h := sha256.New()
+	buf := make([]byte, checkpointChunkSize)
+	var size int64
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			size += int64(n)
+			if err := enc.Encode(CheckpointFrame{Name: name, Data: buf[:n]}); err != nil {
+				return entry, err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return entry, err
+		}
+	}
+	entry.SHA256, entry.Size = h.Sum(nil), size
+	log.Printf("Checkpoint sent file %s (%d bytes)\n", name, size)
+	return entry, enc.Encode(CheckpointFrame{
+		Name:   name,
+		Done:   true,
+		Size:   size,
+		SHA256: entry.SHA256,
+	})
+}
+
+// CheckpointHave returns the hex-encoded SHA-256 of every complete file
+// in dir, keyed by name, for resuming an interrupted ReceiveCheckpoint.
+// Partially received files are removed.
+func CheckpointHave(dir string) (map[string]string, error) {
+	ls, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]string, len(ls))
+	for _, fi := range ls {
+		path := filepath.Join(dir, fi.Name())
+		if fi.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(fi.Name(), partialSuffix) {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		sum, _, err := hashFile(path)
+		if err != nil {
+			return nil, err
+		}
+		have[fi.Name()] = hex.EncodeToString(sum)
+	}
+	return have, nil
+}
+
+// ReceiveCheckpoint reads a checkpoint stream written by Checkpoint into
+// dir, verifying the size and SHA-256 of every file. The directory may
+// hold files from an earlier, interrupted transfer. Files are only given
+// their final names once verified, so that an interrupted transfer can
+// be resumed using CheckpointHave. The contents of dir must not be used
+// unless ReceiveCheckpoint returns a nil error.
+func ReceiveCheckpoint(r io.Reader, dir string) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	dec := gob.NewDecoder(gzr)
+
+	received := map[string][]byte{}
+	var cur *os.File
+	defer func() {
+		if cur != nil {
+			cur.Close()
+		}
+	}()
+	h := sha256.New()
+	var size int64
+
+	for {
+		var frame CheckpointFrame
+		err := dec.Decode(&frame)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errors.New("checkpoint stream ended before its manifest")
+		} else if err != nil {
+			return err
+		}
+
+		if frame.Manifest != nil {
+			return finishCheckpoint(dir, frame.Manifest, received)
+		}
+		if frame.Name != filepath.Base(frame.Name) || frame.Name == "." || frame.Name == ".." {
+			return fmt.Errorf("invalid checkpoint file name %q", frame.Name)
+		}
+		path := filepath.Join(dir, frame.Name)
+
+		if frame.Reused {
+			sum, n, err := hashFile(path)
+			if err != nil {
+				return err
+			}
+			if n != frame.Size || !bytes.Equal(sum, frame.SHA256) {
+				return fmt.Errorf("local copy of %s doesn't match checkpoint", frame.Name)
+			}
+			received[frame.Name] = sum
+			continue
+		}
+
+		if cur != nil && cur.Name() != path+partialSuffix {
+			return fmt.Errorf("checkpoint file %s interleaved with %s", frame.Name, cur.Name())
+		}
+		if cur == nil {
+			cur, err = os.Create(path + partialSuffix)
+			if err != nil {
+				return err
+			}
+			h.Reset()
+			size = 0
+		}
+		if len(frame.Data) > 0 {
+			if _, err := cur.Write(frame.Data); err != nil {
+				return err
+			}
+			h.Write(frame.Data)
+			size += int64(len(frame.Data))
+		}
+		if !frame.Done {
+			continue
+		}
+
+		sum := h.Sum(nil)
+		if size != frame.Size || !bytes.Equal(sum, frame.SHA256) {
+			return fmt.Errorf("checkpoint file %s failed verification: got %d bytes with SHA-256 %x, expected %d bytes with SHA-256 %x",
+				frame.Name, size, sum, frame.Size, frame.SHA256)
+		}
+		if err := cur.Sync(); err != nil {
+			return err
+		}
+		if err := cur.Close(); err != nil {
+			return err
+		}
+		cur = nil
+		if err := os.Rename(path+partialSuffix, path); err != nil {
+			return err
+		}
+		received[frame.Name] = sum
+		log.Printf("Downloaded %s (%d bytes)\n", frame.Name, size)
+	}
+}
+
+// finishCheckpoint verifies that every file in the manifest was received
+// intact and removes any leftover files that aren't part of it.
+func finishCheckpoint(dir string, manifest []CheckpointManifestEntry, received map[string][]byte) error {
+	inManifest := make(map[string]bool, len(manifest))
+	for _, e := range manifest {
+		sum, ok := received[e.Name]
+		if !ok {
+			return fmt.Errorf("checkpoint file %s is in the manifest but wasn't received", e.Name)
+		}
+		if !bytes.Equal(sum, e.SHA256) {
+			return fmt.Errorf("checkpoint file %s doesn't match the manifest", e.Name)
+		}
+		inManifest[e.Name] = true
+	}
+	if len(received) != len(manifest) {
+		return errors.New("checkpoint received files missing from the manifest")
+	}
+
+	ls, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range ls {
+		if !inManifest[fi.Name()] {
+			if err := os.RemoveAll(filepath.Join(dir, fi.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func hashFile(path string) ([]byte, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+func hashReader(r io.Reader) ([]byte, int64, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h.Sum(nil), n, nil
+}
@@ -0,0 +1,130 @@
+package codenames
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	src, err := ioutil.TempDir("", "test-checkpoint-src-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ps PebbleStore
+	ps.DB, err = pebble.Open(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.DB.Close()
+
+	games := randomGames(10)
+	for _, g := range games {
+		if err := ps.Save(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := ps.Checkpoint(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := buf.Bytes()
+
+	dst, err := ioutil.TempDir("", "test-checkpoint-dst-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A truncated stream must be rejected, but leave the files that were
+	// fully received in place so the transfer can be resumed.
+	err = ReceiveCheckpoint(bytes.NewReader(checkpoint[:len(checkpoint)*3/4]), dst)
+	if err == nil {
+		t.Fatal("expected an error receiving a truncated checkpoint")
+	}
+	have, err := CheckpointHave(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := ps.Checkpoint(&buf, have); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReceiveCheckpoint(&buf, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	// The received checkpoint should open and contain every game.
+	restoredStore := PebbleStore{}
+	restoredStore.DB, err = pebble.Open(dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restoredStore.DB.Close()
+	restored, err := restoredStore.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != len(games) {
+		t.Errorf("restored %d games from checkpoint, expected %d", len(restored), len(games))
+	}
+	for id := range games {
+		if _, ok := restored[id]; !ok {
+			t.Errorf("game %q missing from checkpoint", id)
+		}
+	}
+}
+
+func TestCheckpointRejectsCorruptLocalFile(t *testing.T) {
+	src, err := ioutil.TempDir("", "test-checkpoint-src-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ps PebbleStore
+	ps.DB, err = pebble.Open(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.DB.Close()
+	for _, g := range randomGames(3) {
+		if err := ps.Save(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst, err := ioutil.TempDir("", "test-checkpoint-dst-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := ps.Checkpoint(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReceiveCheckpoint(&buf, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	// Claim to hold an up-to-date copy of a file, but corrupt it. The
+	// receiver must notice the local copy doesn't match.
+	have, err := CheckpointHave(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range have {
+		if err := ioutil.WriteFile(filepath.Join(dst, name), []byte("garbage"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+	buf.Reset()
+	if err := ps.Checkpoint(&buf, have); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReceiveCheckpoint(&buf, dst); err == nil {
+		t.Fatal("expected an error receiving a checkpoint over a corrupt local file")
+	}
+}
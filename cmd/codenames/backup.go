@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/jbowens/codenames"
+)
+
+const backupUsage = `usage: codenames backup -out <file> [-created-after <time>]
+       [-created-before <time>]
+
+Exports persisted games, rooms, archived games and word packs as JSON
+lines. Only records created within the given times are exported. Times
+are RFC 3339, for example 2020-11-14T18:00:00Z. Use - to write to
+stdout. The server must not be running, since it holds a lock on the
+DB.`
+
+const restoreUsage = `usage: codenames restore -in <file> [-force]
+
+Imports games, rooms, archived games and word packs written by
+codenames backup into the DB, upgrading games to the current schema
+version. Use - to read from stdin. Refuses to restore into a non-empty
+DB directory unless -force is given.`
+
+func runBackup(args []string) error {
+	var out, createdAfter, createdBefore string
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, backupUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&out, "out", "", "file to write the backup to")
+	fs.StringVar(&createdAfter, "created-after", "", "only back up records created at or after this time")
+	fs.StringVar(&createdBefore, "created-before", "", "only back up records created before this time")
+	fs.Parse(args)
+	if out == "" {
+		fs.Usage()
+		return errors.New("-out is required")
+	}
+
+	var after, before time.Time
+	var err error
+	if createdAfter != "" {
+		if after, err = time.Parse(time.RFC3339, createdAfter); err != nil {
+			return fmt.Errorf("-created-after: %w", err)
+		}
+	}
+	if createdBefore != "" {
+		if before, err = time.Parse(time.RFC3339, createdBefore); err != nil {
+			return fmt.Errorf("-created-before: %w", err)
+		}
+	}
+
+	db, err := pebble.Open(pebbleDir(), &pebble.Options{ErrorIfNotExists: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ps := &codenames.PebbleStore{DB: db}
+
+	var w io.Writer = os.Stdout
+	if out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	counts, err := ps.Export(w, after, before)
+	if err != nil {
+		return err
+	}
+	if f, ok := w.(*os.File); ok && f != os.Stdout {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Backed up %s.\n", counts)
+	return nil
+}
+
+func runRestore(args []string) error {
+	var in string
+	var force bool
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, restoreUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&in, "in", "", "backup file to restore from")
+	fs.BoolVar(&force, "force", false, "restore into a non-empty DB, overwriting records with the same ID")
+	fs.Parse(args)
+	if in == "" {
+		fs.Usage()
+		return errors.New("-in is required")
+	}
+
+	var r io.Reader = os.Stdin
+	if in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	dir := pebbleDir()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	if !force {
+		if err := checkEmptyDir(dir); err != nil {
+			return fmt.Errorf("%w (use -force to restore anyway)", err)
+		}
+	}
+
+	db, err := pebble.Open(dir, &pebble.Options{})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ps := &codenames.PebbleStore{DB: db}
+
+	counts, err := ps.Import(r)
+	if err != nil {
+		return fmt.Errorf("restored %s before error: %w", counts, err)
+	}
+	fmt.Fprintf(os.Stderr, "Restored %s.\n", counts)
+	return nil
+}
+
+// checkEmptyDir returns an error if dir contains any files, to avoid
+// clobbering an existing DB.
+func checkEmptyDir(dir string) error {
+	ls, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(ls) > 0 {
+		return fmt.Errorf("directory %q is not empty: aborting", dir)
+	}
+	return nil
+}
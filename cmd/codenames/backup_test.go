@@ -0,0 +1,80 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/jbowens/codenames"
+)
+
+// openStore opens the Pebble DB in dir, creating it if necessary.
+func openStore(t *testing.T, dir string) *codenames.PebbleStore {
+	t.Helper()
+	db, err := pebble.Open(dir, &pebble.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &codenames.PebbleStore{DB: db}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	tmp := t.TempDir()
+	src, dst := filepath.Join(tmp, "src"), filepath.Join(tmp, "dst")
+	backup := filepath.Join(tmp, "backup.jsonl")
+
+	createdAt := time.Date(2020, 11, 14, 18, 0, 0, 0, time.UTC)
+	ps := openStore(t, src)
+	words := make([]string, 25)
+	for i := range words {
+		words[i] = string(rune('A' + i))
+	}
+	game := &codenames.Game{
+		ID:        "friday-night",
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+		Words:     words,
+		GameState: codenames.GameState{WordSet: words, Revealed: make([]bool, 25)},
+	}
+	if err := ps.Save(game); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.SaveRoom(&codenames.Room{ID: "friday-night", Owner: "sam", CreatedAt: createdAt}); err != nil {
+		t.Fatal(err)
+	}
+	ps.DB.Close()
+
+	t.Setenv("PEBBLE_DIR", src)
+	if err := runBackup([]string{"-out", backup, "-created-before", "2020-11-15T00:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PEBBLE_DIR", dst)
+	if err := runRestore([]string{"-in", backup}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runRestore([]string{"-in", backup}); err == nil {
+		t.Error("expected an error restoring into a non-empty DB without -force")
+	}
+	if err := runRestore([]string{"-in", backup, "-force"}); err != nil {
+		t.Errorf("restoring with -force: %s", err)
+	}
+
+	ps = openStore(t, dst)
+	defer ps.DB.Close()
+	games, err := ps.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, ok := games["friday-night"]; !ok || !g.CreatedAt.Equal(createdAt) || len(g.Words) != 25 {
+		t.Errorf("restored games %+v", games)
+	}
+	rooms, err := ps.RestoreRooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := rooms["friday-night"]; !ok || r.Owner != "sam" {
+		t.Errorf("restored rooms %+v", rooms)
+	}
+}
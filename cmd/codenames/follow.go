@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/jbowens/codenames"
+)
+
+const followUsage = `usage: codenames follow -primary-url <url>
+
+Continuously replicates the change feed of a primary codenames server
+into the local DB. The local DB should first be bootstrapped from the
+primary with -bootstrap-url. If the follower falls too far behind the
+primary, or the primary is replaced by an older copy, the local DB is
+bootstrapped again from the primary. The follower trims its own change
+feed like the primary does. To fail over, stop the follower and start
+the server on the same DB directory.`
+
+func runFollow(args []string) error {
+	var primaryURL string
+	fs := flag.NewFlagSet("follow", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, followUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&primaryURL, "primary-url", "",
+		"URL of the primary codenames server to replicate")
+	fs.Parse(args)
+	if primaryURL == "" {
+		fs.Usage()
+		return errors.New("-primary-url is required")
+	}
+
+	dir := pebbleDir()
+	ps, err := openFollowerDB(dir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if ps != nil {
+			ps.DB.Close()
+		}
+	}()
+
+	const maxBackoff = 30 * time.Second
+	backoff := time.Second
+	var trimmer feedTrimmer
+	for {
+		trimmer.maybeTrim(ps)
+		applied, err := followOnce(ps, primaryURL, &trimmer)
+		if err == errFollowerTooFarBehind {
+			log.Printf("[FOLLOW] %s; bootstrapping again\n", err)
+			db := ps.DB
+			ps = nil
+			if err := db.Close(); err != nil {
+				return err
+			}
+			if err := rebootstrap(primaryURL, dir); err != nil {
+				return err
+			}
+			if ps, err = openFollowerDB(dir); err != nil {
+				return err
+			}
+			backoff = time.Second
+			continue
+		}
+		if applied > 0 {
+			backoff = time.Second
+		}
+		if err != nil {
+			log.Printf("[FOLLOW] %s; retrying in %s\n", err, backoff)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+	}
+}
+
+var errFollowerTooFarBehind = errors.New("the primary has no changes following the follower's")
+
+func openFollowerDB(dir string) (*codenames.PebbleStore, error) {
+	var opts pebble.Options
+	opts.ErrorIfNotExists = true
+	db, err := pebble.Open(dir, &opts)
+	if err != nil {
+		return nil, err
+	}
+	return &codenames.PebbleStore{DB: db}, nil
+}
+
+// rebootstrap replaces the DB in dir, which must be closed, with a fresh
+// checkpoint of the primary. The old DB is moved aside until the
+// checkpoint is in place, and the bootstrap is retried until it
+// succeeds, resuming interrupted transfers.
+func rebootstrap(primaryURL, dir string) error {
+	stale := filepath.Clean(dir) + ".stale"
+	if _, err := os.Stat(dir); err == nil {
+		os.RemoveAll(stale)
+		if err := os.Rename(dir, stale); err != nil {
+			return fmt.Errorf("moving aside the old DB: %w", err)
+		}
+	}
+
+	const maxBackoff = 5 * time.Minute
+	backoff := time.Second
+	for {
+		err := os.MkdirAll(dir, os.ModePerm)
+		if err == nil {
+			err = bootstrap(primaryURL, dir)
+		}
+		if err == nil {
+			break
+		}
+		log.Printf("[FOLLOW] Bootstrapping: %s; retrying in %s\n", err, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	if err := os.RemoveAll(stale); err != nil {
+		log.Printf("[FOLLOW] Removing the old DB: %s\n", err)
+	}
+	log.Printf("[FOLLOW] Bootstrapped from %q\n", primaryURL)
+	return nil
+}
+
+// feedTrimmer trims the follower's own change feed with the primary's
+// retention, so that the follower's disk usage doesn't grow without
+// bound and it can serve as a primary after failing over.
+type feedTrimmer struct {
+	last time.Time
+}
+
+func (t *feedTrimmer) maybeTrim(ps *codenames.PebbleStore) {
+	if time.Since(t.last) < time.Hour {
+		return
+	}
+	t.last = time.Now()
+	if err := ps.TrimChanges(time.Now().Add(-changeRetention)); err != nil {
+		log.Printf("[FOLLOW] PebbleStore.TrimChanges: %s\n", err)
+	}
+}
+
+// followOnce requests the primary's change feed following the local
+// DB's most recent change and applies changes until the response ends.
+// It returns the number of changes applied.
+func followOnce(ps *codenames.PebbleStore, primaryURL string, trimmer *feedTrimmer) (int, error) {
+	since, err := ps.LastSeq()
+	if err != nil {
+		return 0, err
+	}
+	u, err := url.Parse(primaryURL)
+	if err != nil {
+		return 0, err
+	}
+	u.Path = "/changes"
+	u.RawQuery = url.Values{"since": {strconv.FormatUint(since, 10)}}.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.SetBasicAuth("admin", os.Getenv("BOOTSTRAPPW"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusGone:
+		return 0, errFollowerTooFarBehind
+	case resp.StatusCode != 200:
+		return 0, fmt.Errorf("changes returned %s status code", resp.Status)
+	}
+
+	var applied int
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var c codenames.Change
+		err := dec.Decode(&c)
+		if err == io.EOF {
+			return applied, nil
+		} else if err != nil {
+			return applied, err
+		}
+		if err := ps.ApplyChanges([]codenames.Change{c}); err != nil {
+			return applied, err
+		}
+		applied++
+		log.Printf("[FOLLOW] Applied change %d (%s %s)\n", c.Seq, c.Op, c.Key)
+		// Streams from a busy primary may never end, so the feed is
+		// also trimmed between changes.
+		trimmer.maybeTrim(ps)
+	}
+}
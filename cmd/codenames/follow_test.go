@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jbowens/codenames"
+)
+
+// changeFeed serves the change feed of ps like a primary's /changes
+// endpoint, ending each response once it has caught up.
+func changeFeed(t *testing.T, ps *codenames.PebbleStore) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if _, pw, _ := req.BasicAuth(); req.URL.Path != "/changes" || pw != "hunter2" {
+			http.NotFound(rw, req)
+			return
+		}
+		since, err := strconv.ParseUint(req.URL.Query().Get("since"), 10, 64)
+		if err != nil {
+			http.Error(rw, "invalid since parameter", 400)
+			return
+		}
+		changes, _, err := ps.Changes(since, 1000)
+		if err == codenames.ErrChangesTruncated || err == codenames.ErrChangesAhead {
+			http.Error(rw, err.Error(), http.StatusGone)
+			return
+		} else if err != nil {
+			t.Error(err)
+			http.Error(rw, err.Error(), 500)
+			return
+		}
+		enc := json.NewEncoder(rw)
+		for _, c := range changes {
+			enc.Encode(c)
+		}
+	}))
+}
+
+func TestFollow(t *testing.T) {
+	t.Setenv("BOOTSTRAPPW", "hunter2")
+	tmp := t.TempDir()
+	primary := openStore(t, filepath.Join(tmp, "primary"))
+	defer primary.DB.Close()
+	follower := openStore(t, filepath.Join(tmp, "follower"))
+	defer follower.DB.Close()
+
+	now := time.Now()
+	for _, id := range []string{"friday-night", "saturday-night"} {
+		if err := primary.SaveRoom(&codenames.Room{ID: id, CreatedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := changeFeed(t, primary)
+	defer srv.Close()
+
+	// An empty follower applies the whole feed, and then only what's new.
+	var trimmer feedTrimmer
+	if applied, err := followOnce(follower, srv.URL, &trimmer); err != nil || applied != 2 {
+		t.Fatalf("applied %d changes, expected 2 (err %v)", applied, err)
+	}
+	if err := primary.DeleteRoom(&codenames.Room{ID: "saturday-night"}); err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := followOnce(follower, srv.URL, &trimmer); err != nil || applied != 1 {
+		t.Fatalf("applied %d changes, expected 1 (err %v)", applied, err)
+	}
+	rooms, err := follower.RestoreRooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rooms["friday-night"]; !ok || len(rooms) != 1 {
+		t.Errorf("follower has rooms %v, expected only friday-night", rooms)
+	}
+	primarySeq, _ := primary.LastSeq()
+	if seq, err := follower.LastSeq(); err != nil || seq != primarySeq {
+		t.Errorf("follower is at change %d, expected %d (err %v)", seq, primarySeq, err)
+	}
+
+	// A follower with changes the primary doesn't have must bootstrap
+	// again.
+	ahead := openStore(t, filepath.Join(tmp, "ahead"))
+	defer ahead.DB.Close()
+	for i := 0; i < 5; i++ {
+		if err := ahead.SaveRoom(&codenames.Room{ID: "friday-night", CreatedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := followOnce(ahead, srv.URL, &trimmer); err != errFollowerTooFarBehind {
+		t.Errorf("following a primary behind the follower: %v, expected errFollowerTooFarBehind", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jbowens/codenames"
+)
+
+const lintWordsUsage = `usage: codenames lint-words [-language <tag>] [-board-size <n>] <file>...
+
+Checks word packs for problems before they're uploaded: duplicates once
+canonicalized, words that are substrings or compounds of each other,
+words with spaces or punctuation, words mixing scripts, and packs too
+small for a board. Files ending in .csv are read as CSV, files ending in
+.json as JSON word packs, and anything else as one word per line.
+Problems are printed with their line numbers, and the command fails if
+there are any.`
+
+// lintMediaTypes maps file extensions to the media types of word packs.
+var lintMediaTypes = map[string]string{
+	".csv":  "text/csv",
+	".json": "application/json",
+}
+
+func runLintWords(args []string) error {
+	var lang string
+	var boardSize int
+	fs := flag.NewFlagSet("lint-words", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, lintWordsUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&lang, "language", "", "BCP 47 language tag of the words, for case mapping")
+	fs.IntVar(&boardSize, "board-size", 25, "number of words on a board")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("expected at least one file")
+	}
+
+	var total int
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		problems, err := codenames.LintWordPack(f, lintMediaTypes[filepath.Ext(path)], lang, boardSize)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for _, p := range problems {
+			if p.Line == 0 {
+				fmt.Printf("%s: %s\n", path, p.Message)
+			} else {
+				fmt.Printf("%s:%s\n", path, p)
+			}
+		}
+		total += len(problems)
+	}
+	if total > 0 {
+		return fmt.Errorf("found %d problems", total)
+	}
+	return nil
+}
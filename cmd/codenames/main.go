@@ -1,12 +1,8 @@
 package main
 
 import (
-	"bytes"
-	"compress/gzip"
-	"encoding/gob"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
@@ -23,25 +19,59 @@ import (
 )
 
 const defaultListenAddr = ":9091"
-const expiryDur = -24 * time.Hour
+const changeRetention = 24 * time.Hour
+
+// commands holds the subcommands that may be given as the first
+// argument. Without a subcommand, codenames runs the server.
+var commands = map[string]func(args []string) error{
+	"backup":     runBackup,
+	"follow":     runFollow,
+	"lint-words": runLintWords,
+	"quarantine": runQuarantine,
+	"replay":     runReplay,
+	"restore":    runRestore,
+}
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	var bootstrapURL string
 	var listenAddr string
+	var gameIDFormat string
+	var gameIDEntropy int
+	retention := codenames.DefaultRetentionPolicy
 	flag.StringVar(&listenAddr, "listen-addr", defaultListenAddr,
 		"address for server to listen on")
 	flag.StringVar(&bootstrapURL, "bootstrap-url", "",
 		"URL of an existing codenames server to bootstrap the DB from")
+	flag.DurationVar(&retention.Finished, "retain-finished", retention.Finished,
+		"how long to keep finished games after their last activity")
+	flag.DurationVar(&retention.Idle, "retain-idle", retention.Idle,
+		"how long to keep any game after its last activity")
+	flag.DurationVar(&retention.Room, "retain-rooms", retention.Room,
+		"how long to keep rooms after their last activity")
+	flag.DurationVar(&retention.Archive, "retain-archive", retention.Archive,
+		"how long to keep completed games in the archive")
+
+	flag.StringVar(&gameIDFormat, "game-id-format", string(codenames.IDFormatWords),
+		"format of suggested game IDs: words or code")
+	flag.IntVar(&gameIDEntropy, "game-id-entropy", codenames.DefaultIDEntropy,
+		"minimum number of random bits in suggested game IDs")
 
 	flag.Parse()
 
 	// Open a Pebble DB to persist games to disk.
-	dir := os.Getenv("PEBBLE_DIR")
-	if dir == "" {
-		dir = filepath.Join(".", "db")
-	}
+	dir := pebbleDir()
 	err := os.MkdirAll(dir, os.ModePerm)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "MkdirAll(%q): %s\n", dir, err)
@@ -70,15 +100,17 @@ func main() {
 	}
 	defer db.Close()
 
-	ps := &codenames.PebbleStore{DB: db}
+	ps := &codenames.PebbleStore{DB: db, RewriteMigrated: true}
 
-	// Delete any games created too long ago.
-	err = ps.DeleteExpired(time.Now().Add(expiryDur))
+	// Delete any games that expired while the server wasn't running.
+	// The server expires games from then on.
+	expired, err := ps.DeleteExpired(retention, time.Now())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "PebbleStore.DeletedExpired: %s\n", err)
 		os.Exit(1)
 	}
-	go deleteExpiredPeriodically(ps)
+	log.Printf("[STARTUP] Deleted %d expired games.\n", expired)
+	go maintainStorePeriodically(ps, retention)
 
 	// Restore games from disk.
 	games, err := ps.Restore()
@@ -88,6 +120,29 @@ func main() {
 	}
 	log.Printf("[STARTUP] Restored %d games from disk.\n", len(games))
 
+	rooms, err := ps.RestoreRooms()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "PebbleStore.RestoreRooms: %s\n", err)
+		os.Exit(1)
+	}
+	log.Printf("[STARTUP] Restored %d rooms from disk.\n", len(rooms))
+
+	packs, err := ps.RestoreWordPacks()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "PebbleStore.RestoreWordPacks: %s\n", err)
+		os.Exit(1)
+	}
+	log.Printf("[STARTUP] Restored %d word packs from disk.\n", len(packs))
+
+	quarantined, err := ps.Quarantined()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "PebbleStore.Quarantined: %s\n", err)
+		os.Exit(1)
+	}
+	if len(quarantined) > 0 {
+		log.Printf("[STARTUP] %d quarantined records; inspect them with `codenames quarantine list`.\n", len(quarantined))
+	}
+
 	if traceDir := os.Getenv("TRACE"); len(traceDir) > 0 {
 		log.Printf("[STARTUP] Traces enabled; storing most recent trace in %q", traceDir)
 		go tracePeriodically(traceDir)
@@ -98,26 +153,57 @@ func main() {
 		Server: http.Server{
 			Addr: listenAddr,
 		},
-		Store: ps,
+		Store:         ps,
+		Retention:     retention,
+		GameIDFormat:  codenames.IDFormat(gameIDFormat),
+		GameIDEntropy: gameIDEntropy,
 	}
-	if err := server.Start(games); err != nil {
+	if err := server.Start(games, rooms, packs); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %s\n", err)
 	}
 }
 
+// pebbleDir returns the directory of the Pebble DB.
+func pebbleDir() string {
+	dir := os.Getenv("PEBBLE_DIR")
+	if dir == "" {
+		dir = filepath.Join(".", "db")
+	}
+	return dir
+}
+
+// bootstrap downloads a checkpoint of the DB from the server at
+// bootstrapURL into dir, which must be empty. The checkpoint is
+// downloaded into a sibling directory and only moved into place once
+// every file has been verified. If the transfer is interrupted, running
+// bootstrap again resumes it, skipping files that were already received.
 func bootstrap(bootstrapURL, dir string) error {
-	ls, err := ioutil.ReadDir(dir)
+	if err := checkEmptyDir(dir); err != nil {
+		return err
+	}
+	u, err := url.Parse(bootstrapURL)
 	if err != nil {
 		return err
 	}
-	if len(ls) > 0 {
-		return fmt.Errorf("directory %q is not empty: aborting\n", dir)
+
+	tmpDir := filepath.Clean(dir) + ".bootstrap"
+	if err := os.MkdirAll(tmpDir, os.ModePerm); err != nil {
+		return err
 	}
-	u, err := url.Parse(bootstrapURL)
+	have, err := codenames.CheckpointHave(tmpDir)
 	if err != nil {
 		return err
 	}
+	if len(have) > 0 {
+		log.Printf("Resuming bootstrap with %d previously downloaded files\n", len(have))
+	}
+	q := url.Values{}
+	for name, sum := range have {
+		q.Add("have", name+":"+sum)
+	}
 	u.Path = "/checkpoint"
+	u.RawQuery = q.Encode()
+
 	req, err := http.NewRequest("GET", u.String(), nil)
 	if err != nil {
 		return err
@@ -132,38 +218,30 @@ func bootstrap(bootstrapURL, dir string) error {
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("checkpoint returned %s status code\n", resp.Status)
 	}
-	b, err := ioutil.ReadAll(resp.Body)
+	err = codenames.ReceiveCheckpoint(resp.Body, tmpDir)
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "receiving checkpoint into %s (re-run to resume)", tmpDir)
 	}
-	r := bytes.NewReader(b)
-	gzr, err := gzip.NewReader(r)
-	if err != nil {
+
+	// Every file has been verified; atomically move the checkpoint into
+	// place. The destination is known to be empty.
+	if err := os.Remove(dir); err != nil {
 		return err
 	}
-	dec := gob.NewDecoder(gzr)
-	for {
-		var cf codenames.CheckpointFile
-		err := dec.Decode(&cf)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return err
-		}
-		err = ioutil.WriteFile(filepath.Join(dir, cf.Name), cf.Data, os.ModePerm)
-		if err != nil {
-			return errors.Wrapf(err, "writing %s", filepath.Base(cf.Name))
-		}
-		log.Printf("Downloaded %s (%d bytes)\n", cf.Name, len(cf.Data))
-	}
-	return nil
+	return os.Rename(tmpDir, dir)
 }
 
-func deleteExpiredPeriodically(ps *codenames.PebbleStore) {
+// maintainStorePeriodically removes data that the server doesn't hold
+// in memory, and so doesn't expire itself, from the store.
+func maintainStorePeriodically(ps *codenames.PebbleStore, retention codenames.RetentionPolicy) {
 	for range time.Tick(time.Hour) {
-		err := ps.DeleteExpired(time.Now().Add(expiryDur))
+		_, err := ps.DeleteExpiredArchives(retention, time.Now())
+		if err != nil {
+			log.Printf("PebbleStore.DeleteExpiredArchives: %s\n", err)
+		}
+		err = ps.TrimChanges(time.Now().Add(-changeRetention))
 		if err != nil {
-			log.Printf("PebbleStore.DeletedExpired: %s\n", err)
+			log.Printf("PebbleStore.TrimChanges: %s\n", err)
 		}
 	}
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/jbowens/codenames"
+)
+
+const quarantineUsage = `usage: codenames quarantine <list|purge>
+
+Inspects or purges records that couldn't be decoded when restoring
+games and were moved to the /quarantine/ key prefix. The server must
+not be running, since it holds a lock on the DB.`
+
+func runQuarantine(args []string) error {
+	fs := flag.NewFlagSet("quarantine", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, quarantineUsage) }
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected exactly one of list or purge")
+	}
+
+	db, err := pebble.Open(pebbleDir(), &pebble.Options{ErrorIfNotExists: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ps := &codenames.PebbleStore{DB: db}
+
+	switch fs.Arg(0) {
+	case "list":
+		records, err := ps.Quarantined()
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			fmt.Printf("%s\t%s\t%s\n", r.QuarantinedAt.Format("2006-01-02 15:04:05"), r.Key, r.Error)
+			fmt.Printf("\t%s\n", r.Value)
+		}
+		fmt.Printf("%d quarantined records\n", len(records))
+	case "purge":
+		records, err := ps.Quarantined()
+		if err != nil {
+			return err
+		}
+		if err := ps.PurgeQuarantine(); err != nil {
+			return err
+		}
+		fmt.Printf("Purged %d quarantined records\n", len(records))
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown quarantine command %q", fs.Arg(0))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jbowens/codenames"
+)
+
+const replayUsage = `usage: codenames replay -in <file>
+
+Prints the evolution of a game's board, one action at a time. The input
+is a game as returned by /history/game, or any JSON object with a game's
+seed, perm_index, word_set and actions. Use - to read from stdin.`
+
+func runReplay(args []string) error {
+	var in string
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, replayUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&in, "in", "", "file containing the game to replay")
+	fs.Parse(args)
+	if in == "" {
+		fs.Usage()
+		return errors.New("-in is required")
+	}
+
+	var r io.Reader = os.Stdin
+	if in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var g codenames.Game
+	if err := json.NewDecoder(r).Decode(&g); err != nil {
+		return err
+	}
+
+	games, err := codenames.Replay(g.ID, g.CreatedAt, g.GameState, g.GameOptions, g.Actions)
+	if err != nil {
+		return err
+	}
+	for i, step := range games {
+		if i == 0 {
+			fmt.Printf("Initial board (%s starts):\n", step.StartingTeam)
+			printBoard(os.Stdout, step)
+			fmt.Println()
+			continue
+		}
+		a := g.Actions[i-1]
+		team := games[i-1].CurrentTeam()
+		switch a.Type {
+		case codenames.ActionGuess:
+			fmt.Printf("Step %d: %s guessed %s (%s)\n", i, team, step.Words[a.Index], step.Layout[a.Index])
+		case codenames.ActionClue:
+			fmt.Printf("Step %d: %s's spymaster gave the clue %q for %d\n", i, team, a.Word, a.Number)
+		case codenames.ActionEndTurn:
+			fmt.Printf("Step %d: %s ended their turn\n", i, team)
+		default:
+			fmt.Printf("Step %d: unknown action %q\n", i, a.Type)
+		}
+		printBoard(os.Stdout, step)
+		fmt.Println()
+	}
+	if winner := games[len(games)-1].WinningTeam; winner != nil {
+		fmt.Printf("%s wins.\n", *winner)
+	}
+	return nil
+}
+
+// printBoard writes the board as a grid, marking revealed words with the
+// first letter of their team.
+func printBoard(w io.Writer, g *codenames.Game) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for row := 0; row*5 < len(g.Words); row++ {
+		var cells []string
+		for i := row * 5; i < row*5+5 && i < len(g.Words); i++ {
+			mark := " "
+			if g.Revealed[i] {
+				mark = strings.ToUpper(g.Layout[i].String()[:1])
+			}
+			cells = append(cells, fmt.Sprintf("[%s] %s", mark, g.Words[i]))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	tw.Flush()
+}
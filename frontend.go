@@ -1,10 +1,10 @@
 package codenames
 
 import (
-	"math/rand"
+	"log"
 	"net/http"
+	"net/url"
 	"path/filepath"
-	"strings"
 )
 
 const tpl = `
@@ -21,6 +21,8 @@ const tpl = `
         <script type="text/javascript">
              {{if .SelectedGameID}}
              window.selectedGameID = "{{.SelectedGameID}}";
+             window.joinURL = "{{.JoinURL}}";
+             window.qrCodeURL = "{{.QRCodeURL}}";
              {{end}}
              window.autogeneratedGameID = "{{.AutogeneratedGameID}}";
         </script>
@@ -45,6 +47,10 @@ const tpl = `
 type templateParameters struct {
 	SelectedGameID      string
 	AutogeneratedGameID string
+	// JoinURL is the link to the selected game, and QRCodeURL the path
+	// of a QR code of it, for sharing the game from a shared screen.
+	JoinURL   string
+	QRCodeURL string
 }
 
 func (s *Server) handleIndex(rw http.ResponseWriter, req *http.Request) {
@@ -53,39 +59,41 @@ func (s *Server) handleIndex(rw http.ResponseWriter, req *http.Request) {
 		http.NotFound(rw, req)
 		return
 	}
+	// Send players to the normalized form of a new game's ID, so that
+	// "/Friday Night" and "/friday-night" are the same game.
+	if id != "" && !s.gameExists(id) {
+		normalized, err := NormalizeGameID(id)
+		if err != nil {
+			http.Error(rw, err.Error(), 400)
+			return
+		}
+		if normalized != id {
+			http.Redirect(rw, req, "/"+normalized, http.StatusFound)
+			return
+		}
+		if err := s.validateGameID(id); err != nil {
+			http.Error(rw, err.Error(), 400)
+			return
+		}
+	}
 
-	autogeneratedID := s.getAutogeneratedID()
+	// The suggested ID is reserved for this visitor, so that nobody else
+	// is offered it before they create their game.
+	autogeneratedID, err := s.gameIDs.Generate()
+	if err != nil {
+		log.Printf("Unable to generate a game ID: %s\n", err)
+	}
 
-	err := s.tpl.Execute(rw, templateParameters{
+	params := templateParameters{
 		SelectedGameID:      id,
 		AutogeneratedGameID: autogeneratedID,
-	})
+	}
+	if id != "" {
+		params.JoinURL = s.joinURL(req, id, "", "")
+		params.QRCodeURL = "/room-qr?" + url.Values{"room_id": {id}}.Encode()
+	}
+	err = s.tpl.Execute(rw, params)
 	if err != nil {
 		http.Error(rw, "error rendering", http.StatusInternalServerError)
 	}
 }
-
-func (s *Server) getAutogeneratedID() string {
-	const attemptsPerWordCount = 5
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	var words []string
-	autogeneratedID := ""
-	for i := 0; ; i++ {
-		wordCount := 2 + i/attemptsPerWordCount
-
-		words = words[:0]
-		for j := 0; j < wordCount; j++ {
-			w := s.gameIDWords[rand.Intn(len(s.gameIDWords))]
-			words = append(words, w)
-		}
-
-		autogeneratedID = strings.Join(words, "-")
-		if _, ok := s.games[autogeneratedID]; !ok {
-			break
-		}
-	}
-	return autogeneratedID
-}
@@ -17,7 +17,12 @@ export class Game extends React.Component {
       mounted: true,
       settings: Settings.load(),
       mode: 'game',
-      codemaster: false,
+      clueWord: '',
+      clueNumber: 1,
+      // Join links from QR codes can open the game as a spymaster.
+      codemaster:
+        new URLSearchParams(window.location.search).get('role') ==
+        'spymaster',
     };
   }
 
@@ -183,11 +188,13 @@ export class Game extends React.Component {
     axios
       .post('/guess', {
         game_id: this.state.game.id,
+        state_id: this.state.game.state_id,
         index: idx,
       })
       .then(({ data }) => {
         this.setState({ game: data });
-      });
+      })
+      .catch((err) => this.handleConflict(err));
   }
 
   public currentTeam() {
@@ -214,13 +221,69 @@ export class Game extends React.Component {
     axios
       .post('/end-turn', {
         game_id: this.state.game.id,
+        state_id: this.state.game.state_id,
         current_round: this.state.game.round,
       })
       .then(({ data }) => {
         this.setState({ game: data });
+      })
+      .catch((err) => this.handleConflict(err));
+  }
+
+  // currentClue returns the clue given during the current turn, if any.
+  public currentClue() {
+    const actions = this.state.game.actions || [];
+    for (let i = actions.length - 1; i >= 0; i--) {
+      if (actions[i].round != this.state.game.round) {
+        break;
+      }
+      if (actions[i].type == 'clue') {
+        return actions[i];
+      }
+    }
+    return null;
+  }
+
+  public giveClue(e) {
+    e.preventDefault();
+    if (!this.state.clueWord.trim()) {
+      return;
+    }
+    axios
+      .post('/clue', {
+        game_id: this.state.game.id,
+        state_id: this.state.game.state_id,
+        word: this.state.clueWord,
+        number: Number(this.state.clueNumber),
+      })
+      .then(({ data }) => {
+        this.setState({ game: data, clueWord: '' });
+      })
+      .catch((err) => {
+        if (err.response && err.response.status == 400) {
+          alert(err.response.data);
+          return;
+        }
+        this.handleConflict(err);
       });
   }
 
+  // A 409 means someone else changed the game first. The response holds
+  // the current game, so show it instead of retrying.
+  public handleConflict(err) {
+    if (!err.response || err.response.status != 409) {
+      return;
+    }
+    const game = err.response.data;
+    this.setState((oldState) => {
+      const stateToUpdate = { game: game };
+      if (oldState.game && game.created_at != oldState.game.created_at) {
+        stateToUpdate.codemaster = false;
+      }
+      return stateToUpdate;
+    });
+  }
+
   public nextGame(e) {
     e.preventDefault();
     // Ask for confirmation when current game hasn't finished
@@ -236,12 +299,17 @@ export class Game extends React.Component {
         game_id: this.state.game.id,
         word_set: this.state.game.word_set,
         create_new: true,
+        state_id: this.state.game.state_id,
         timer_duration_ms: this.state.game.timer_duration_ms,
         enforce_timer: this.state.game.enforce_timer,
+        max_difficulty: this.state.game.max_difficulty,
+        family_safe: this.state.game.family_safe,
+        category: this.state.game.category,
       })
       .then(({ data }) => {
         this.setState({ game: data, codemaster: false });
-      });
+      })
+      .catch((err) => this.handleConflict(err));
   }
 
   public toggleSettingsView(e) {
@@ -303,6 +371,37 @@ export class Game extends React.Component {
       );
     }
 
+    let clue;
+    const currentClue = this.currentClue();
+    if (currentClue) {
+      clue = (
+        <div id="clue" className="status-text">
+          {currentClue.word}, {currentClue.number}
+        </div>
+      );
+    } else if (!this.state.game.winning_team && this.state.codemaster) {
+      clue = (
+        <form id="clue-form" onSubmit={(e) => this.giveClue(e)}>
+          <input
+            type="text"
+            placeholder="Clue"
+            aria-label="Clue"
+            value={this.state.clueWord}
+            onChange={(e) => this.setState({ clueWord: e.target.value })}
+          />
+          <input
+            type="number"
+            min="0"
+            max="9"
+            aria-label="Number of cards"
+            value={this.state.clueNumber}
+            onChange={(e) => this.setState({ clueNumber: e.target.value })}
+          />
+          <button type="submit">Give clue</button>
+        </form>
+      );
+    }
+
     let otherTeam = 'blue';
     if (this.state.game.starting_team == 'blue') {
       otherTeam = 'red';
@@ -313,8 +412,26 @@ export class Game extends React.Component {
       shareLink = (
         <div id="share">
           Send this link to friends:&nbsp;
-          <a className="url" href={window.location.href}>
-            {window.location.href}
+          <a className="url" href={window.joinURL || window.location.href}>
+            {window.joinURL || window.location.href}
+          </a>
+          {window.qrCodeURL && (
+            <a
+              className="qr-link"
+              href={window.qrCodeURL + '&format=svg&scale=16'}
+              target="_blank"
+            >
+              QR code
+            </a>
+          )}
+          <a
+            className="print-link"
+            href={
+              '/print?game_id=' + encodeURIComponent(this.props.gameID)
+            }
+            target="_blank"
+          >
+            Print
           </a>
         </div>
       );
@@ -365,6 +482,7 @@ export class Game extends React.Component {
           <div id="status" className="status-text">
             {status}
           </div>
+          {clue}
           {endTurnButton}
         </div>
         <div className={'board ' + statusClass}>
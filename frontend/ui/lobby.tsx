@@ -3,7 +3,6 @@ import axios from 'axios';
 import CustomWords from '~/ui/custom_words';
 import WordSetToggle from '~/ui/wordset_toggle';
 import TimerSettings from '~/ui/timer_settings';
-import OriginalWords from '~/words.json';
 
 export const Lobby = ({ defaultGameID }) => {
   const [newGameName, setNewGameName] = React.useState(defaultGameID);
@@ -11,13 +10,24 @@ export const Lobby = ({ defaultGameID }) => {
     'English (Original)',
   ]);
   const [customWordsText, setCustomWordsText] = React.useState('');
-  const [words, setWords] = React.useState({ ...OriginalWords, Custom: [] });
+  const [customWords, setCustomWords] = React.useState([]);
+  const [wordSets, setWordSets] = React.useState([]);
   const [warning, setWarning] = React.useState(null);
   const [timer, setTimer] = React.useState(null);
   const [enforceTimerEnabled, setEnforceTimerEnabled] = React.useState(false);
 
+  React.useEffect(() => {
+    axios.get('/word-sets').then(({ data }) => {
+      setWordSets(data.word_sets);
+    });
+  }, []);
+
   let selectedWordCount = selectedWordSets
-    .map((l) => words[l].length)
+    .map((l) =>
+      l == 'Custom'
+        ? customWords.length
+        : wordSets.filter((ws) => ws.name == l).map((ws) => ws.size)[0] || 0
+    )
     .reduce((a, cv) => a + cv, 0);
 
   React.useEffect(() => {
@@ -32,11 +42,7 @@ export const Lobby = ({ defaultGameID }) => {
       return;
     }
 
-    let combinedWordSet = selectedWordSets
-      .map((l) => words[l])
-      .reduce((a, w) => a.concat(w), []);
-
-    if (combinedWordSet.length < 25) {
+    if (selectedWordCount < 25) {
       setWarning('Selected wordsets do not include at least 25 words.');
       return;
     }
@@ -44,7 +50,8 @@ export const Lobby = ({ defaultGameID }) => {
     axios
       .post('/next-game', {
         game_id: newGameName,
-        word_set: combinedWordSet,
+        word_sets: selectedWordSets.filter((l) => l != 'Custom'),
+        word_set: selectedWordSets.includes('Custom') ? customWords : [],
         create_new: false,
         timer_duration_ms:
           timer && timer.length ? timer[0] * 60 * 1000 + timer[1] * 1000 : 0,
@@ -53,6 +60,13 @@ export const Lobby = ({ defaultGameID }) => {
       .then(() => {
         const newURL = (document.location.pathname = '/' + newGameName);
         window.location = newURL;
+      })
+      .catch((err) => {
+        setWarning(
+          err.response && err.response.data
+            ? err.response.data
+            : 'Unable to create the game.'
+        );
       });
   }
 
@@ -68,8 +82,7 @@ export const Lobby = ({ defaultGameID }) => {
     setSelectedWordSets(wordSets);
   };
 
-  let langs = Object.keys(OriginalWords);
-  langs.sort();
+  let langs = wordSets.map((ws) => ws.name);
 
   return (
     <div id="lobby">
@@ -119,7 +132,6 @@ export const Lobby = ({ defaultGameID }) => {
                 {langs.map((_label) => (
                   <WordSetToggle
                     key={_label}
-                    words={words[_label]}
                     label={_label}
                     selected={selectedWordSets.includes(_label)}
                     onToggle={(e) => toggleWordSet(_label)}
@@ -131,14 +143,13 @@ export const Lobby = ({ defaultGameID }) => {
                 words={customWordsText}
                 onWordChange={(w) => {
                   setCustomWordsText(w);
-                  setWords({
-                    ...words,
-                    Custom: w
+                  setCustomWords(
+                    w
                       .trim()
                       .split(',')
                       .map((w) => w.trim())
-                      .filter((w) => w.length > 0),
-                  });
+                      .filter((w) => w.length > 0)
+                  );
                 }}
                 selected={selectedWordSets.includes('Custom')}
                 onToggle={(e) => toggleWordSet('Custom')}
@@ -1,7 +1,6 @@
 import * as React from 'react';
-import OriginalWords from '~/words.json';
 
-const WordSetToggle = ({ words, label, selected, onToggle }) => {
+const WordSetToggle = ({ label, selected, onToggle }) => {
   const [expanded, setExpanded] = React.useState(false);
 
   return (
@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -83,6 +86,15 @@ type GameState struct {
 	Round     int      `json:"round"`
 	Revealed  []bool   `json:"revealed"`
 	WordSet   []string `json:"word_set"`
+	// Mix describes how boards are drawn from the packs making up
+	// WordSet, if it's a mix of weighted packs.
+	Mix []PackShare `json:"mix,omitempty"`
+	// Deferred holds words played recently, before this cycle through
+	// the permutation began. They're moved to the end of the cycle.
+	Deferred []string `json:"deferred,omitempty"`
+	// Tags holds the tags of each word in WordSet, if the word set is
+	// tagged.
+	Tags []WordTags `json:"tags,omitempty"`
 }
 
 func (gs GameState) anyRevealed() bool {
@@ -103,21 +115,65 @@ func randomState(words []string) GameState {
 	}
 }
 
-// nextGameState returns a new GameState for the next game.
-func nextGameState(state GameState) GameState {
+// nextGameState returns a new GameState for the next game, whose board
+// is restricted to the words allowed by the filters in opts.
+func nextGameState(state GameState, opts GameOptions) GameState {
 	state.PermIndex = state.PermIndex + wordsPerGame
-	if state.PermIndex+wordsPerGame >= len(state.WordSet) {
+	if board := state.filter(opts); board.PermIndex != state.PermIndex || board.exhausted() {
 		state.Seed = rand.Int63()
 		state.PermIndex = 0
+		state.Deferred = nil
 	}
 	state.Revealed = make([]bool, wordsPerGame)
 	state.Round = 0
 	return state
 }
 
+// deferring returns the state with the recently played words in recent
+// deferred to the end of its cycle through the permutation.
+func (state GameState) deferring(recent []string) GameState {
+	inSet := make(map[string]bool, len(state.WordSet))
+	for _, w := range state.WordSet {
+		inSet[w] = true
+	}
+	state.Deferred = nil
+	for _, w := range recent {
+		if inSet[w] {
+			state.Deferred = append(state.Deferred, w)
+		}
+	}
+	return state
+}
+
+// deferLast reorders order, a permutation of indexes into words, so that
+// indexes of deferred words come last, those played longest ago first.
+// The order is otherwise kept.
+func deferLast(order []int, words []string, deferred map[string]int) []int {
+	if len(deferred) == 0 {
+		return order
+	}
+	reordered := append([]int(nil), order...)
+	sort.SliceStable(reordered, func(i, j int) bool {
+		return deferred[words[reordered[i]]] < deferred[words[reordered[j]]]
+	})
+	return reordered
+}
+
+// deferredSet ranks the state's deferred words by how recently they were
+// played, a game's worth of words at a time. Words that aren't deferred
+// rank zero.
+func (state GameState) deferredSet() map[string]int {
+	deferred := make(map[string]int, len(state.Deferred))
+	for i, w := range state.Deferred {
+		deferred[w] = 1 + i/wordsPerGame
+	}
+	return deferred
+}
+
 type Game struct {
 	GameState
 	ID             string    `json:"id"`
+	Version        uint64    `json:"version"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
 	StartingTeam   Team      `json:"starting_team"`
@@ -125,16 +181,59 @@ type Game struct {
 	Words          []string  `json:"words"`
 	Layout         []Team    `json:"layout"`
 	RoundStartedAt time.Time `json:"round_started_at,omitempty"`
+	Pinned         bool      `json:"pinned,omitempty"`
+	Actions        []Action  `json:"actions,omitempty"`
+	BoardCode      string    `json:"board_code,omitempty"`
 	GameOptions
+
+	clock Clock
+}
+
+// now returns the current time according to the game's clock.
+func (g *Game) now() time.Time {
+	if g.clock == nil {
+		return SystemClock.Now()
+	}
+	return g.clock.Now()
+}
+
+// Action types.
+const (
+	ActionGuess   = "guess"
+	ActionEndTurn = "end_turn"
+	ActionClue    = "clue"
+)
+
+// maxClueLength is the longest clue, in bytes, that can be given.
+const maxClueLength = 64
+
+// Action records a single move made during a game, in the order the
+// moves were made.
+type Action struct {
+	Type  string    `json:"type"`
+	Index int       `json:"index"`
+	Round int       `json:"round"`
+	At    time.Time `json:"at"`
+	// Word and Number are the clue given by a clue action.
+	Word   string `json:"word,omitempty"`
+	Number int    `json:"number,omitempty"`
 }
 
 type GameOptions struct {
 	TimerDurationMS int64 `json:"timer_duration_ms,omitempty"`
 	EnforceTimer    bool  `json:"enforce_timer,omitempty"`
+
+	// Filters restricting the words on the board, according to their
+	// tags. See GameOptions.allows.
+	MaxDifficulty int    `json:"max_difficulty,omitempty"`
+	FamilySafe    bool   `json:"family_safe,omitempty"`
+	Category      string `json:"category,omitempty"`
 }
 
+// StateID identifies the current state of the game. It changes whenever
+// the game is updated.
 func (g *Game) StateID() string {
-	return fmt.Sprintf("%019d", g.UpdatedAt.UnixNano())
+	return strconv.FormatUint(g.Version, 10)
 }
 
 func (g *Game) checkWinningCondition() {
@@ -163,53 +262,120 @@ func (g *Game) checkWinningCondition() {
 	}
 }
 
+// endedByAssassin returns true if the game ended with a team revealing
+// the assassin.
+func (g *Game) endedByAssassin() bool {
+	for i, t := range g.Layout {
+		if t == Black && g.Revealed[i] {
+			return true
+		}
+	}
+	return false
+}
+
 func (g *Game) NextTurn(currentTurn int) bool {
 	if g.WinningTeam != nil {
 		return false
 	}
-	// TODO: remove currentTurn != 0 once we can be sure all
-	// clients are running up-to-date versions of the frontend.
-	if g.Round != currentTurn && currentTurn != 0 {
+	if g.Round != currentTurn {
 		return false
 	}
-	g.UpdatedAt = time.Now()
+	g.UpdatedAt = g.now()
+	g.Actions = append(g.Actions, Action{Type: ActionEndTurn, Round: g.Round, At: g.UpdatedAt})
 	g.Round++
-	g.RoundStartedAt = time.Now()
+	g.RoundStartedAt = g.UpdatedAt
 	return true
 }
 
+// Clue returns the clue given during the current turn, or nil if the
+// current team's spymaster hasn't given one.
+func (g *Game) Clue() *Action {
+	for i := len(g.Actions) - 1; i >= 0 && g.Actions[i].Round == g.Round; i-- {
+		if g.Actions[i].Type == ActionClue {
+			return &g.Actions[i]
+		}
+	}
+	return nil
+}
+
+// GiveClue records the current team's spymaster's clue: a word and the
+// number of cards it relates to.
+func (g *Game) GiveClue(word string, number int) error {
+	if g.WinningTeam != nil {
+		return errors.New("the game is over")
+	}
+	word = strings.TrimSpace(word)
+	if word == "" {
+		return errors.New("clue is required")
+	}
+	if len(word) > maxClueLength {
+		return fmt.Errorf("clue is longer than %d bytes", maxClueLength)
+	}
+	if number < 0 || number > len(g.Words) {
+		return fmt.Errorf("clue number %d is invalid", number)
+	}
+	if g.Clue() != nil {
+		return errors.New("a clue has already been given this turn")
+	}
+	for i, w := range g.Words {
+		if !g.Revealed[i] && strings.EqualFold(w, word) {
+			return fmt.Errorf("%q is a word on the board", w)
+		}
+	}
+	g.UpdatedAt = g.now()
+	g.Actions = append(g.Actions, Action{Type: ActionClue, Round: g.Round, At: g.UpdatedAt, Word: word, Number: number})
+	return nil
+}
+
 func (g *Game) Guess(idx int) error {
-	if idx > len(g.Layout) || idx < 0 {
+	if g.WinningTeam != nil {
+		return errors.New("the game is over")
+	}
+	if idx >= len(g.Layout) || idx < 0 {
 		return fmt.Errorf("index %d is invalid", idx)
 	}
 	if g.Revealed[idx] {
 		return errors.New("cell has already been revealed")
 	}
-	g.UpdatedAt = time.Now()
+	g.UpdatedAt = g.now()
 	g.Revealed[idx] = true
+	g.Actions = append(g.Actions, Action{Type: ActionGuess, Index: idx, Round: g.Round, At: g.UpdatedAt})
 
 	if g.Layout[idx] == Black {
-		winners := g.currentTeam().Other()
+		winners := g.CurrentTeam().Other()
 		g.WinningTeam = &winners
 		return nil
 	}
 
 	g.checkWinningCondition()
-	if g.Layout[idx] != g.currentTeam() {
+	if g.Layout[idx] != g.CurrentTeam() {
 		g.Round = g.Round + 1
-		g.RoundStartedAt = time.Now()
+		g.RoundStartedAt = g.UpdatedAt
 	}
 	return nil
 }
 
-func (g *Game) currentTeam() Team {
+// CurrentTeam returns the team whose turn it is.
+func (g *Game) CurrentTeam() Team {
 	if g.Round%2 == 0 {
 		return g.StartingTeam
 	}
 	return g.StartingTeam.Other()
 }
 
-func newGame(id string, state GameState, opts GameOptions) *Game {
+// newGame creates a game from state. Games created from the same state
+// have the same words, layout and starting team. The board is drawn from
+// the words allowed by the filters in opts, which must leave enough words
+// for a board (see GameState.checkFilters), but the game keeps the whole
+// word set so that later games aren't restricted by its filters. The
+// game reads the time from clock, or the system clock if it's nil.
+func newGame(id string, state GameState, opts GameOptions, clock Clock) *Game {
+	if clock == nil {
+		clock = SystemClock
+	}
+	board := state.filter(opts)
+	state.PermIndex = board.PermIndex
+	now := clock.Now()
 	// consistent randomness across games with the same seed
 	seedRnd := rand.New(rand.NewSource(state.Seed))
 	// distinct randomness across games with same seed
@@ -217,23 +383,34 @@ func newGame(id string, state GameState, opts GameOptions) *Game {
 
 	game := &Game{
 		ID:             id,
-		CreatedAt:      time.Now(),
-		UpdatedAt:      time.Now(),
+		Version:        1,
+		CreatedAt:      now,
+		UpdatedAt:      now,
 		StartingTeam:   Team(randRnd.Intn(2)) + Red,
 		Words:          make([]string, 0, wordsPerGame),
 		Layout:         make([]Team, 0, wordsPerGame),
 		GameState:      state,
-		RoundStartedAt: time.Now(),
+		RoundStartedAt: now,
 		GameOptions:    opts,
+		clock:          clock,
 	}
 
-	// Pick the next `wordsPerGame` words from the
-	// randomly generated permutation
-	perm := seedRnd.Perm(len(state.WordSet))
-	permIndex := state.PermIndex
-	for _, i := range perm[permIndex : permIndex+wordsPerGame] {
-		w := state.WordSet[perm[i]]
-		game.Words = append(game.Words, w)
+	if len(board.Mix) > 0 {
+		game.Words = board.mixedWords(seedRnd)
+	} else {
+		// Pick the next `wordsPerGame` words from the
+		// randomly generated permutation
+		perm := seedRnd.Perm(len(board.WordSet))
+		order := make([]int, len(perm))
+		for i := range perm {
+			order[i] = perm[perm[i]]
+		}
+		order = deferLast(order, board.WordSet, board.deferredSet())
+		permIndex := board.PermIndex
+		for _, i := range order[permIndex : permIndex+wordsPerGame] {
+			w := board.WordSet[i]
+			game.Words = append(game.Words, w)
+		}
 	}
 
 	// Pick a random permutation of team assignments.
@@ -249,6 +426,14 @@ func newGame(id string, state GameState, opts GameOptions) *Game {
 		shuffle(randRnd, teamAssignments)
 	}
 	game.Layout = teamAssignments
+
+	if len(state.Mix) > 0 {
+		// Mixed words are grouped by pack, so spread them across
+		// the board.
+		randRnd.Shuffle(len(game.Words), func(i, j int) {
+			game.Words[i], game.Words[j] = game.Words[j], game.Words[i]
+		})
+	}
 	return game
 }
 
@@ -2,7 +2,9 @@ package codenames
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jbowens/dictionary"
 )
@@ -28,7 +30,7 @@ func BenchmarkGameMarshal(b *testing.B) {
 		Round:    0,
 		Revealed: make([]bool, 25),
 		WordSet:  d.Words(),
-	}, GameOptions{})
+	}, GameOptions{}, SystemClock)
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
 		_, err = json.Marshal(g)
@@ -46,13 +48,210 @@ func TestGameShuffle(t *testing.T) {
 
 	m := map[string]int{}
 	for i := 0; i < gamesWithoutRepeats; i++ {
-		g := newGame("foo", currState, GameOptions{})
+		g := newGame("foo", currState, GameOptions{}, SystemClock)
 		for _, w := range g.Words {
 			if prevI, ok := m[w]; ok {
 				t.Errorf("Word %q appeared twice, once in game %d and once in game %d.", w, prevI, i)
 			}
 			m[w] = i
 		}
-		currState = nextGameState(currState)
+		currState = nextGameState(currState, GameOptions{})
+	}
+}
+
+// playToWin reveals every card belonging to team in g, ending the game.
+func playToWin(t *testing.T, g *Game, team Team) {
+	t.Helper()
+	for i, c := range g.Layout {
+		if c == team && !g.Revealed[i] {
+			if err := g.Guess(i); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if g.WinningTeam == nil || *g.WinningTeam != team {
+		t.Fatalf("expected %s to win", team)
+	}
+}
+
+func TestScoreboard(t *testing.T) {
+	var sb Scoreboard
+	var clearedTurns int
+	state := randomState(testWords)
+	for i, winner := range []Team{Red, Red, Blue, Red} {
+		g := newGame("foo", state, GameOptions{}, SystemClock)
+		playToWin(t, g, winner)
+		if g.endedByAssassin() {
+			t.Fatal("game won by clearing cards ended by the assassin")
+		}
+		sb.record(*g.WinningTeam, false, g.Round+1)
+		clearedTurns += g.Round + 1
+		if sb.Games != i+1 {
+			t.Fatalf("expected %d games on the scoreboard, got %d", i+1, sb.Games)
+		}
+		state = nextGameState(state, GameOptions{})
+	}
+
+	// Lose a game by revealing the assassin.
+	g := newGame("foo", state, GameOptions{}, SystemClock)
+	for i, c := range g.Layout {
+		if c == Black {
+			if err := g.Guess(i); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if !g.endedByAssassin() {
+		t.Fatal("game wasn't ended by the assassin")
+	}
+	sb.record(*g.WinningTeam, true, g.Round+1)
+	loser := g.StartingTeam
+
+	if sb.Games != 5 {
+		t.Errorf("expected 5 games, got %d", sb.Games)
+	}
+	if got := sb.RedWins + sb.BlueWins; got != 5 {
+		t.Errorf("expected 5 wins, got %d", got)
+	}
+	if loser == Red && sb.RedAssassinLosses != 1 || loser == Blue && sb.BlueAssassinLosses != 1 {
+		t.Errorf("assassin loss by %s wasn't recorded: %+v", loser, sb)
+	}
+	if sb.LongestStreakTeam != Red || sb.LongestStreak < 2 {
+		t.Errorf("expected red's streak of at least 2, got %s with %d", sb.LongestStreakTeam, sb.LongestStreak)
+	}
+	// The game ended by the assassin doesn't count towards the turns
+	// taken to win.
+	if sb.ClearedWins != 4 || sb.TotalTurnsToWin != clearedTurns {
+		t.Errorf("expected 4 cleared wins in %d turns, got %d in %d", clearedTurns, sb.ClearedWins, sb.TotalTurnsToWin)
+	}
+	if sb.AverageTurnsToWin != float64(clearedTurns)/4 {
+		t.Errorf("average turns to win %f doesn't match total %d", sb.AverageTurnsToWin, clearedTurns)
+	}
+}
+
+func TestGameReadsClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 11, 14, 18, 0, 0, 0, time.UTC))
+	g := newGame("timer", randomState(testWords), GameOptions{TimerDurationMS: 60000}, clock)
+	if !g.CreatedAt.Equal(clock.Now()) || !g.RoundStartedAt.Equal(clock.Now()) {
+		t.Fatalf("game created at %s, round started at %s, expected %s", g.CreatedAt, g.RoundStartedAt, clock.Now())
+	}
+
+	clock.Advance(59 * time.Second)
+	if err := g.Guess(guessable(g, g.CurrentTeam())); err != nil {
+		t.Fatal(err)
+	}
+	if !g.UpdatedAt.Equal(clock.Now()) {
+		t.Errorf("game updated at %s, expected %s", g.UpdatedAt, clock.Now())
+	}
+
+	clock.Advance(time.Minute)
+	if !g.NextTurn(g.Round) {
+		t.Fatal("unable to end the turn")
+	}
+	if !g.RoundStartedAt.Equal(clock.Now()) {
+		t.Errorf("round started at %s, expected %s", g.RoundStartedAt, clock.Now())
+	}
+}
+
+func TestGiveClue(t *testing.T) {
+	g := newGame("clues", randomState(testWords), GameOptions{}, SystemClock)
+	if g.Clue() != nil {
+		t.Fatal("new game has a clue")
+	}
+	for _, tc := range []struct {
+		word   string
+		number int
+	}{
+		{"", 1},
+		{"  ", 1},
+		{strings.ToLower(g.Words[3]), 1},
+		{strings.Repeat("x", maxClueLength+1), 1},
+		{"animal", -1},
+		{"animal", 26},
+	} {
+		if err := g.GiveClue(tc.word, tc.number); err == nil {
+			t.Errorf("expected an error giving the clue %q %d", tc.word, tc.number)
+		}
+	}
+
+	if err := g.GiveClue(" animal ", 2); err != nil {
+		t.Fatal(err)
+	}
+	if c := g.Clue(); c == nil || c.Word != "animal" || c.Number != 2 || c.Round != 0 {
+		t.Fatalf("clue is %+v", c)
+	}
+	if err := g.GiveClue("vegetable", 1); err == nil {
+		t.Error("expected an error giving a second clue in the same turn")
+	}
+	g.NextTurn(0)
+	if g.Clue() != nil {
+		t.Error("clue carried over to the next turn")
+	}
+	if err := g.GiveClue("vegetable", 0); err != nil {
+		t.Error(err)
+	}
+
+	// Words on the board can be given as clues once they're revealed.
+	idx := guessable(g, g.CurrentTeam())
+	if err := g.Guess(idx); err != nil {
+		t.Fatal(err)
+	}
+	g.NextTurn(g.Round)
+	if err := g.GiveClue(g.Words[idx], 1); err != nil {
+		t.Error(err)
+	}
+
+	playToWin(t, g, Red)
+	if err := g.GiveClue("mineral", 1); err == nil {
+		t.Error("expected an error giving a clue after the game ended")
+	}
+	if err := g.Guess(guessable(g, Blue)); err == nil {
+		t.Error("expected an error guessing after the game ended")
+	}
+}
+
+// guessable returns the index of an unrevealed word belonging to team.
+func guessable(g *Game, team Team) int {
+	for i, t := range g.Layout {
+		if t == team && !g.Revealed[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestAllocateCards(t *testing.T) {
+	testCases := []struct {
+		sizes   []int
+		weights []float64
+		mins    []int
+		want    []int
+	}{
+		{sizes: []int{400, 400}, weights: []float64{1, 1}, mins: []int{0, 0}, want: []int{13, 12}},
+		{sizes: []int{400, 400}, weights: []float64{7, 3}, mins: []int{0, 0}, want: []int{18, 7}},
+		{sizes: []int{400, 400}, weights: []float64{1, 0}, mins: []int{0, 5}, want: []int{20, 5}},
+		// Packs can't give more cards than they have words.
+		{sizes: []int{400, 3}, weights: []float64{1, 1}, mins: []int{0, 0}, want: []int{22, 3}},
+		{sizes: []int{400, 400, 400}, weights: []float64{0, 0, 0}, mins: []int{10, 10, 5}, want: []int{10, 10, 5}},
+	}
+	for _, tc := range testCases {
+		got, err := allocateCards(wordsPerGame, tc.sizes, tc.weights, tc.mins)
+		if err != nil {
+			t.Errorf("allocateCards(%v, %v, %v): %s", tc.sizes, tc.weights, tc.mins, err)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("allocateCards(%v, %v, %v) = %v, expected %v", tc.sizes, tc.weights, tc.mins, got, tc.want)
+				break
+			}
+		}
+	}
+
+	if _, err := allocateCards(wordsPerGame, []int{10, 10}, []float64{1, 1}, []int{0, 0}); err == nil {
+		t.Error("expected an error when packs are too small")
+	}
+	if _, err := allocateCards(wordsPerGame, []int{400, 400}, []float64{1, 1}, []int{20, 20}); err == nil {
+		t.Error("expected an error when minimums exceed the board")
 	}
 }
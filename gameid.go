@@ -0,0 +1,389 @@
+package codenames
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IDFormat is the format of generated game IDs.
+type IDFormat string
+
+const (
+	// IDFormatWords generates IDs of dictionary words, like
+	// "lemon-harbor-tiger".
+	IDFormatWords IDFormat = "words"
+	// IDFormatCode generates short codes, like "k7qz-m2xd".
+	IDFormatCode IDFormat = "code"
+)
+
+const (
+	// DefaultIDEntropy is the default minimum number of random bits in a
+	// generated game ID.
+	DefaultIDEntropy = 36
+	// DefaultIDReservation is how long a generated game ID is reserved
+	// for the visitor it was given to by default.
+	DefaultIDReservation = time.Hour
+
+	// idAttemptsPerLength is the number of collisions after which
+	// generated IDs get longer.
+	idAttemptsPerLength = 5
+	// maxIDAttempts is the number of collisions after which the
+	// generator gives up.
+	maxIDAttempts = 100
+	// idCodeGroup is the number of characters between dashes in codes.
+	idCodeGroup = 4
+	// maxReservedIDs is the number of generated IDs that can be reserved
+	// at once. Once it's reached, the reservations closest to expiring
+	// are dropped first.
+	maxReservedIDs = 10000
+)
+
+// idCodeAlphabet is Crockford's base 32 alphabet, which omits letters
+// easily confused with digits or each other.
+const idCodeAlphabet = "0123456789abcdefghjkmnpqrstvwxyz"
+
+// IDGenerator generates random, unguessable game IDs. Each ID is reserved
+// for a while after it's generated, so that two visitors can't be given
+// the same ID before either of them creates the game. IDs containing
+// blocked terms, including across the boundaries between words, are
+// never generated.
+type IDGenerator struct {
+	Format IDFormat
+	// Entropy is the minimum number of random bits in each ID.
+	Entropy int
+	// Reservation is how long a generated ID is reserved.
+	Reservation time.Duration
+	Clock       Clock
+	// Taken reports whether an ID is already in use. It's called
+	// without any of the generator's locks held.
+	Taken func(id string) bool
+
+	words     []string
+	isWord    map[string]bool
+	blocklist []string
+
+	mu       sync.Mutex
+	reserved map[string]time.Time // expiry by ID
+	// queue holds reservations in the order they were made, which is
+	// the order they expire in, since each lasts for Reservation. It
+	// may hold reservations since released or made again.
+	queue []reservation
+}
+
+type reservation struct {
+	id     string
+	expiry time.Time
+}
+
+// NewIDGenerator returns an IDGenerator of IDs made from words, never
+// containing any of the terms in blocklist. Words that are themselves
+// blocked terms, or that would have to be normalized to appear in a game
+// ID, such as "ice cream", aren't used. It generates IDs in the words
+// format with the default entropy and reservation.
+func NewIDGenerator(words, blocklist []string) *IDGenerator {
+	g := &IDGenerator{
+		Format:      IDFormatWords,
+		Entropy:     DefaultIDEntropy,
+		Reservation: DefaultIDReservation,
+		Clock:       SystemClock,
+		isWord:      make(map[string]bool),
+	}
+	for _, term := range blocklist {
+		if term = strings.ToLower(strings.TrimSpace(term)); term != "" {
+			g.blocklist = append(g.blocklist, term)
+		}
+	}
+	for _, w := range words {
+		w = strings.ToLower(w)
+		if normalized, err := NormalizeGameID(w); err != nil || normalized != w {
+			continue
+		}
+		if !g.blocked([]string{w}, func(string) bool { return true }) {
+			g.words = append(g.words, w)
+			g.isWord[w] = true
+		}
+	}
+	return g
+}
+
+// Generate returns a new ID, reserving it. It returns an error if no
+// unused ID could be found.
+func (g *IDGenerator) Generate() (string, error) {
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		parts, err := g.random(g.length() + attempt/idAttemptsPerLength)
+		if err != nil {
+			return "", err
+		}
+		var allowWithin func(string) bool
+		if g.Format != IDFormatCode {
+			allowWithin = g.dictionaryWord
+		}
+		if g.blocked(parts, allowWithin) {
+			continue
+		}
+		id := g.join(parts)
+		if g.Taken != nil && g.Taken(id) {
+			continue
+		}
+		if g.reserve(id) {
+			return id, nil
+		}
+	}
+	return "", errors.New("unable to generate an unused game ID")
+}
+
+// Release ends the reservation of id, once a game or room has been
+// created with it.
+func (g *IDGenerator) Release(id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.reserved, id)
+}
+
+// reserve reserves id if it isn't already reserved.
+func (g *IDGenerator) reserve(id string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.Clock.Now()
+	if g.reserved == nil {
+		g.reserved = make(map[string]time.Time)
+	}
+	// Drop expired reservations, and those closest to expiring while
+	// there are too many.
+	for len(g.queue) > 0 && (!now.Before(g.queue[0].expiry) || len(g.reserved) >= maxReservedIDs) {
+		g.dequeue()
+	}
+	if _, ok := g.reserved[id]; ok {
+		return false
+	}
+	// Released reservations stay queued until they would have expired,
+	// so compact the queue if they pile up.
+	if len(g.queue) >= 2*maxReservedIDs {
+		queue := g.queue[:0]
+		for _, r := range g.queue {
+			if expiry, ok := g.reserved[r.id]; ok && expiry.Equal(r.expiry) {
+				queue = append(queue, r)
+			}
+		}
+		g.queue = queue
+	}
+	expiry := now.Add(g.Reservation)
+	g.reserved[id] = expiry
+	g.queue = append(g.queue, reservation{id: id, expiry: expiry})
+	return true
+}
+
+// dequeue removes the first reservation in the queue, ending it unless
+// it has been released or made again since.
+func (g *IDGenerator) dequeue() {
+	r := g.queue[0]
+	g.queue = g.queue[1:]
+	if expiry, ok := g.reserved[r.id]; ok && expiry.Equal(r.expiry) {
+		delete(g.reserved, r.id)
+	}
+}
+
+// length returns the number of words or characters in an ID with at
+// least the generator's entropy.
+func (g *IDGenerator) length() int {
+	bitsPer := math.Log2(float64(len(idCodeAlphabet)))
+	minLength := idCodeGroup
+	if g.Format != IDFormatCode {
+		bitsPer = math.Log2(float64(len(g.words)))
+		minLength = 2
+	}
+	n := int(math.Ceil(float64(g.Entropy) / bitsPer))
+	if n < minLength {
+		n = minLength
+	}
+	return n
+}
+
+// random returns n randomly chosen words, or characters of a code.
+func (g *IDGenerator) random(n int) ([]string, error) {
+	var choices []string
+	switch g.Format {
+	case IDFormatCode:
+		choices = strings.Split(idCodeAlphabet, "")
+	case IDFormatWords, "":
+		choices = g.words
+	default:
+		return nil, fmt.Errorf("unknown game ID format %q", g.Format)
+	}
+	if len(choices) < 2 {
+		return nil, errors.New("not enough words to generate game IDs")
+	}
+	parts := make([]string, n)
+	max := big.NewInt(int64(len(choices)))
+	for i := range parts {
+		// IDs come from crypto/rand so that they can't be predicted
+		// from other IDs.
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = choices[idx.Int64()]
+	}
+	return parts, nil
+}
+
+func (g *IDGenerator) join(parts []string) string {
+	if g.Format != IDFormatCode {
+		return strings.Join(parts, "-")
+	}
+	var b strings.Builder
+	for i, p := range parts {
+		if i > 0 && i%idCodeGroup == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteString(p)
+	}
+	return b.String()
+}
+
+// dictionaryWord returns true if w is one of the words IDs are made of.
+func (g *IDGenerator) dictionaryWord(w string) bool {
+	return g.isWord[w]
+}
+
+// blocked returns true if the parts of an ID, read together, contain a
+// blocked term. A term found entirely within a single part, but not the
+// whole of it, is allowed if allowWithin returns true for the part, so
+// that words like "class" aren't blocked for containing a shorter term.
+// If allowWithin is nil, every term is blocked.
+func (g *IDGenerator) blocked(parts []string, allowWithin func(part string) bool) bool {
+	joined := strings.Join(parts, "")
+	// starts[i] is the offset of parts[i] in joined.
+	starts := make([]int, len(parts)+1)
+	for i, p := range parts {
+		starts[i+1] = starts[i] + len(p)
+	}
+	for _, term := range g.blocklist {
+		for from := 0; from < len(joined); {
+			i := strings.Index(joined[from:], term)
+			if i < 0 {
+				break
+			}
+			begin, end := from+i, from+i+len(term)
+			from = begin + 1
+			if allowWithin == nil {
+				return true
+			}
+			var within bool
+			for p := range parts {
+				if starts[p] <= begin && end <= starts[p+1] && end-begin < len(parts[p]) && allowWithin(parts[p]) {
+					within = true
+					break
+				}
+			}
+			if !within {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Limits on the length of game and room IDs.
+const (
+	minGameIDLength = 3
+	maxGameIDLength = 64
+)
+
+// reservedGameIDs can't be used as game or room IDs, since they're
+// served by other endpoints or would be confusing as names.
+var reservedGameIDs = map[string]bool{
+	"admin":        true,
+	"api":          true,
+	"changes":      true,
+	"checkpoint":   true,
+	"claim-room":   true,
+	"clue":         true,
+	"debug":        true,
+	"end-turn":     true,
+	"game-state":   true,
+	"guess":        true,
+	"help":         true,
+	"history":      true,
+	"index":        true,
+	"join-room":    true,
+	"lobby":        true,
+	"new":          true,
+	"next-game":    true,
+	"print":        true,
+	"replay":       true,
+	"reset-scores": true,
+	"room":         true,
+	"room-qr":      true,
+	"static":       true,
+	"stats":        true,
+	"word-packs":   true,
+	"word-sets":    true,
+}
+
+// NormalizeGameID returns the canonical form of a game or room ID
+// chosen by a player: lower case, with runs of spaces and underscores
+// replaced by dashes. It returns an error if the ID has characters other
+// than ASCII letters, digits and dashes, which rules out lookalike
+// letters from other scripts, or isn't of a reasonable length.
+func NormalizeGameID(id string) (string, error) {
+	id = strings.Join(strings.Fields(strings.Replace(id, "_", " ", -1)), "-")
+	id = strings.ToLower(id)
+	if len(id) < minGameIDLength || len(id) > maxGameIDLength {
+		return "", fmt.Errorf("game IDs must be between %d and %d characters", minGameIDLength, maxGameIDLength)
+	}
+	for _, r := range id {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return "", fmt.Errorf("game IDs may only contain letters, digits and dashes, not %q", r)
+		}
+	}
+	if strings.HasPrefix(id, "-") || strings.HasSuffix(id, "-") || strings.Contains(id, "--") {
+		return "", errors.New("dashes in game IDs must separate words")
+	}
+	return id, nil
+}
+
+// validateGameID returns an error if id isn't an acceptable ID for a new
+// game or room: it must already be normalized, and mustn't be reserved
+// or contain blocked terms. Only the generator's own words may contain a
+// blocked term within them.
+func (s *Server) validateGameID(id string) error {
+	normalized, err := NormalizeGameID(id)
+	if err != nil {
+		return err
+	}
+	if normalized != id {
+		return fmt.Errorf("game ID %q isn't normalized; use %q", id, normalized)
+	}
+	if reservedGameIDs[id] {
+		return fmt.Errorf("game ID %q is reserved", id)
+	}
+	if s.gameIDs != nil && s.gameIDs.blocked(strings.Split(id, "-"), s.gameIDs.dictionaryWord) {
+		return fmt.Errorf("game ID %q isn't allowed", id)
+	}
+	return nil
+}
+
+// releaseGameID ends the reservation of id, if it was generated, once a
+// game or room has been created with it.
+func (s *Server) releaseGameID(id string) {
+	if s.gameIDs != nil {
+		s.gameIDs.Release(id)
+	}
+}
+
+// checkGameID returns an error if there's no game or room with the given
+// ID and id isn't acceptable for a new one. Games and rooms created
+// before IDs were validated remain accessible.
+func (s *Server) checkGameID(id string) error {
+	if s.gameExists(id) {
+		return nil
+	}
+	return s.validateGameID(id)
+}
@@ -0,0 +1,330 @@
+package codenames
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jbowens/dictionary"
+)
+
+func TestIDGeneratorFormats(t *testing.T) {
+	var words []string
+	for _, w := range testWords {
+		words = append(words, strings.ToLower(w))
+	}
+
+	g := NewIDGenerator(words, nil)
+	g.Entropy = 30
+	id, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 400 words give about 8.6 bits each, so 30 bits takes 4 words.
+	if parts := strings.Split(id, "-"); len(parts) != 4 {
+		t.Errorf("generated %q, expected 4 words", id)
+	}
+
+	g.Format = IDFormatCode
+	g.Entropy = 40
+	id, err = g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[0-9a-hjkmnp-tv-z]{4}-[0-9a-hjkmnp-tv-z]{4}$`).MatchString(id) {
+		t.Errorf("generated code %q", id)
+	}
+
+	g.Format = "emoji"
+	if _, err := g.Generate(); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestIDGeneratorReservations(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	g := NewIDGenerator([]string{"red", "blue"}, nil)
+	g.Clock = clock
+	g.Entropy = 1
+
+	// Two words make four IDs of two words, and then longer ones as
+	// collisions pile up. No ID is handed out twice while reserved.
+	seen := map[string]bool{}
+	for i := 0; i < 12; i++ {
+		id, err := g.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[id] {
+			t.Fatalf("%q was generated twice", id)
+		}
+		seen[id] = true
+	}
+
+	// Limited to the four IDs of two words, the generator runs out
+	// until their reservations expire or are released.
+	g.Taken = func(id string) bool { return strings.Count(id, "-") > 1 }
+	clock.Advance(2 * DefaultIDReservation)
+	short := generateAll(t, g, 4)
+	if id, err := generateFree(g); err == nil {
+		t.Errorf("generated %q while all were reserved", id)
+	}
+	g.Release(short[2])
+	if id, err := generateFree(g); err != nil || id != short[2] {
+		t.Errorf("generated %q, %v; expected the released %q", id, err, short[2])
+	}
+	clock.Advance(DefaultIDReservation)
+	generateAll(t, g, 4)
+
+	// IDs in use aren't generated.
+	taken := map[string]bool{"red-red": true, "red-blue": true, "blue-red": true}
+	g.Taken = func(id string) bool { return taken[id] }
+	for i := 0; i < 10; i++ {
+		if id, err := g.Generate(); err != nil || taken[id] {
+			t.Errorf("generated %q, %v", id, err)
+		}
+	}
+	g.Taken = func(id string) bool { return true }
+	if _, err := g.Generate(); err == nil {
+		t.Error("expected an error once every ID is taken")
+	}
+}
+
+// generateFree generates an ID from a generator that may have few free
+// IDs left. The generator only tries a few random IDs of each length,
+// so it's retried before giving up.
+func generateFree(g *IDGenerator) (id string, err error) {
+	for i := 0; i < 50; i++ {
+		if id, err = g.Generate(); err == nil {
+			break
+		}
+	}
+	return id, err
+}
+
+// generateAll generates n IDs, failing unless they're all different.
+func generateAll(t *testing.T, g *IDGenerator, n int) []string {
+	t.Helper()
+	seen := map[string]bool{}
+	var ids []string
+	for i := 0; i < n; i++ {
+		id, err := generateFree(g)
+		if err != nil {
+			t.Fatalf("generating ID %d of %d: %s", i+1, n, err)
+		}
+		if seen[id] {
+			t.Fatalf("%q was generated twice", id)
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestIDGeneratorReleases(t *testing.T) {
+	s := newTestServer(nil)
+	s.gameIDs = NewIDGenerator([]string{"red", "blue"}, nil)
+	s.gameIDs.Entropy = 1
+	s.gameIDs.Taken = func(id string) bool { return strings.Count(id, "-") > 1 }
+
+	// Creating a game or room with a generated ID ends its reservation,
+	// so all four IDs of two words can be generated again.
+	game, _ := s.gameIDs.Generate()
+	room, _ := s.gameIDs.Generate()
+	if code := do(t, s.handleNextGame, "POST", map[string]interface{}{"game_id": game}, nil); code != 200 {
+		t.Fatalf("creating game %q: status %d", game, code)
+	}
+	if code := do(t, s.handleRoom, "POST", map[string]interface{}{"room_id": room}, nil); code != 200 {
+		t.Fatalf("creating room %q: status %d", room, code)
+	}
+	generateAll(t, s.gameIDs, 4)
+
+	// Reservations are capped, dropping those closest to expiring.
+	g := NewIDGenerator(nil, nil)
+	g.Format = IDFormatCode
+	clock := NewFakeClock(time.Now())
+	g.Clock = clock
+	first, _ := g.Generate()
+	clock.Advance(time.Second)
+	for i := 0; i < maxReservedIDs; i++ {
+		if _, err := g.Generate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(g.reserved) != maxReservedIDs {
+		t.Errorf("%d IDs are reserved, expected at most %d", len(g.reserved), maxReservedIDs)
+	}
+	if _, ok := g.reserved[first]; ok {
+		t.Error("the oldest reservation wasn't dropped")
+	}
+
+	// Released reservations don't pile up.
+	for i := 0; i < 3*maxReservedIDs; i++ {
+		id, err := g.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.Release(id)
+	}
+	if len(g.queue) > 2*maxReservedIDs {
+		t.Errorf("%d reservations are queued", len(g.queue))
+	}
+}
+
+func TestIDGeneratorConcurrency(t *testing.T) {
+	g := NewIDGenerator([]string{"red", "blue", "green", "black"}, nil)
+	g.Entropy = 1
+
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				id, err := g.Generate()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if seen[id] {
+					t.Errorf("%q was generated twice", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestIDGeneratorBlocklist(t *testing.T) {
+	g := NewIDGenerator([]string{"class", "ass", "hat", "sun", "bad"}, []string{"ass", "sunb"})
+	for _, w := range g.words {
+		if w == "ass" {
+			t.Error("blocked word wasn't removed")
+		}
+	}
+
+	testCases := []struct {
+		parts   []string
+		blocked bool
+	}{
+		// Words containing a blocked term are fine on their own.
+		{parts: []string{"class", "hat"}, blocked: false},
+		// Blocked terms across words aren't.
+		{parts: []string{"sun", "bad"}, blocked: true},
+		{parts: []string{"hat", "sun", "bad"}, blocked: true},
+		{parts: []string{"bad", "sun"}, blocked: false},
+		// Parts that aren't the generator's words may not contain them.
+		{parts: []string{"hat", "badass"}, blocked: true},
+	}
+	for _, tc := range testCases {
+		if got := g.blocked(tc.parts, g.dictionaryWord); got != tc.blocked {
+			t.Errorf("blocked(%q) = %t, expected %t", tc.parts, got, tc.blocked)
+		}
+	}
+	// Codes are blocked for any term.
+	if !g.blocked(strings.Split("4ass", ""), nil) {
+		t.Error("code containing a blocked term wasn't blocked")
+	}
+
+	for i := 0; i < 50; i++ {
+		id, err := g.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(strings.Replace(id, "-", "", -1), "sunb") {
+			t.Errorf("generated blocked ID %q", id)
+		}
+	}
+}
+
+func TestIDGeneratorWordsAreNormalized(t *testing.T) {
+	g := NewIDGenerator([]string{"Lemon", "ice cream", "New York", "st.patrick", "ox"}, nil)
+	if !equalWords(g.words, []string{"lemon"}) {
+		t.Errorf("generator uses words %q, expected only \"lemon\"", g.words)
+	}
+
+	words, err := dictionary.Load("assets/game-id-words.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g = NewIDGenerator(words.Words(), nil)
+	for i := 0; i < 100; i++ {
+		id, err := g.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if normalized, err := NormalizeGameID(id); err != nil || normalized != id {
+			t.Errorf("generated %q, which normalizes to %q (%v)", id, normalized, err)
+		}
+	}
+}
+
+func TestNormalizeGameID(t *testing.T) {
+	testCases := []struct {
+		id, want string
+	}{
+		{id: "friday-night", want: "friday-night"},
+		{id: "  Friday Night ", want: "friday-night"},
+		{id: "FRIDAY_night", want: "friday-night"},
+		{id: "room42", want: "room42"},
+		{id: "ab"},
+		{id: strings.Repeat("a", maxGameIDLength+1)},
+		{id: "friday--night"},
+		{id: "-friday"},
+		{id: "friday.night"},
+		// Cyrillic "а" and fullwidth "ｆ" look like Latin letters.
+		{id: "fridаy"},
+		{id: "ｆriday"},
+	}
+	for _, tc := range testCases {
+		got, err := NormalizeGameID(tc.id)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("NormalizeGameID(%q) = %q, expected an error", tc.id, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("NormalizeGameID(%q) = %q, %v; expected %q", tc.id, got, err, tc.want)
+		}
+	}
+}
+
+func TestGameIDValidation(t *testing.T) {
+	s := newTestServer(nil)
+	s.gameIDs = NewIDGenerator([]string{"classroom"}, []string{"ass"})
+
+	for id, valid := range map[string]bool{
+		"friday-night":   true,
+		"classroom":      true,
+		"classroom-four": true,
+		"Friday-Night":   false,
+		"next-game":      false,
+		"big-ass":        false,
+		"asshat":         false,
+		"big-asshat":     false,
+		"a b":            false,
+	} {
+		code := do(t, s.handleNextGame, "POST", map[string]interface{}{"game_id": id}, nil)
+		if valid && code != 200 || !valid && code != 400 {
+			t.Errorf("creating game %q: status %d", id, code)
+		}
+		code = do(t, s.handleGameState, "POST", map[string]interface{}{"game_id": id}, nil)
+		if valid && code != 200 || !valid && code != 400 {
+			t.Errorf("getting game %q: status %d", id, code)
+		}
+	}
+
+	// Games that predate validation are still served.
+	s.games["Legacy Game"] = s.newGameHandle(newGame("Legacy Game", randomState(testWords), GameOptions{}, SystemClock))
+	if code := do(t, s.handleGameState, "POST", map[string]interface{}{"game_id": "Legacy Game"}, nil); code != 200 {
+		t.Errorf("getting legacy game: status %d", code)
+	}
+}
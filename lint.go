@@ -0,0 +1,223 @@
+package codenames
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+)
+
+// LintProblem is a problem found in a word pack by LintWordPack.
+type LintProblem struct {
+	// Line is the line of the word in the pack, or for JSON packs the
+	// position of the word in the list. It's zero for problems with the
+	// pack as a whole.
+	Line    int
+	Word    string
+	Message string
+}
+
+func (p LintProblem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("%d: %s: %s", p.Line, p.Word, p.Message)
+}
+
+type lintEntry struct {
+	line int
+	word string
+}
+
+// LintWordPack reads a word pack from r in the format given by mediaType
+// (see parseWordPack) and reports problems that make it unsuitable for
+// play: words that are the same once canonicalized with the case mapping
+// of lang, words contained in other words, such as FIRE and FIREMAN,
+// which make clues illegal, words with spaces or punctuation, words
+// mixing scripts, and packs with fewer than boardSize distinct words.
+func LintWordPack(r io.Reader, mediaType, lang string, boardSize int) ([]LintProblem, error) {
+	entries, lang, err := readLintEntries(r, mediaType, lang)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := parseLanguage(lang)
+	if err != nil {
+		return nil, err
+	}
+	upper := cases.Upper(tag)
+
+	var problems []LintProblem
+	report := func(e lintEntry, format string, args ...interface{}) {
+		problems = append(problems, LintProblem{Line: e.line, Word: e.word, Message: fmt.Sprintf(format, args...)})
+	}
+
+	firstLine := map[string]int{}
+	var unique []lintEntry
+	for _, e := range entries {
+		canonical := canonicalWord(e.word, upper)
+		if canonical == "" {
+			report(e, "word is empty")
+			continue
+		}
+		if line, ok := firstLine[canonical]; ok {
+			report(e, "duplicates line %d as %s", line, canonical)
+			continue
+		}
+		firstLine[canonical] = e.line
+		unique = append(unique, lintEntry{line: e.line, word: canonical})
+
+		if strings.Contains(canonical, " ") {
+			report(e, "contains a space")
+		}
+		if r, ok := firstPunct(canonical); ok {
+			report(e, "contains punctuation %q", r)
+		}
+		if scripts := wordScripts(canonical); mixedScripts(scripts) {
+			report(e, "mixes scripts %s", strings.Join(scripts, " and "))
+		}
+	}
+
+	// Look for each word's substrings among the other words, rather than
+	// comparing every pair of words.
+	for _, e := range unique {
+		runes := []rune(e.word)
+		seen := map[string]bool{}
+		for i := range runes {
+			for j := i + 1; j <= len(runes); j++ {
+				sub := string(runes[i:j])
+				if sub == e.word || seen[sub] {
+					continue
+				}
+				seen[sub] = true
+				if line, ok := firstLine[sub]; ok {
+					report(e, "contains %s from line %d", sub, line)
+				}
+			}
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+
+	if len(unique) < boardSize {
+		problems = append(problems, LintProblem{
+			Message: fmt.Sprintf("has %d distinct words, fewer than the board size of %d", len(unique), boardSize),
+		})
+	}
+	return problems, nil
+}
+
+// readLintEntries reads the words of a word pack along with their line
+// numbers. The language of a JSON pack is used if lang is empty.
+func readLintEntries(r io.Reader, mediaType, lang string) ([]lintEntry, string, error) {
+	var entries []lintEntry
+	switch mediaType {
+	case "application/json":
+		words, _, _, packLang, err := parseWordPack(r, mediaType)
+		if err != nil {
+			return nil, "", err
+		}
+		if lang == "" {
+			lang = packLang
+		}
+		for i, w := range words {
+			entries = append(entries, lintEntry{line: i + 1, word: w})
+		}
+		return entries, lang, nil
+	case "text/csv", "", "text/plain":
+	default:
+		return nil, "", fmt.Errorf("unsupported content type %q", mediaType)
+	}
+
+	// Lines are read one at a time so that problems can be reported by
+	// line, which rules out CSV fields spanning lines.
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		if mediaType != "text/csv" {
+			entries = append(entries, lintEntry{line: line, word: sc.Text()})
+			continue
+		}
+		cr := csv.NewReader(strings.NewReader(sc.Text()))
+		cr.TrimLeadingSpace = true
+		record, err := cr.Read()
+		if err != nil {
+			return nil, "", fmt.Errorf("line %d: invalid CSV: %w", line, err)
+		}
+		for _, w := range record {
+			entries = append(entries, lintEntry{line: line, word: w})
+		}
+	}
+	return entries, lang, sc.Err()
+}
+
+func firstPunct(w string) (rune, bool) {
+	for _, r := range w {
+		if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return r, true
+		}
+	}
+	return 0, false
+}
+
+// wordScripts returns the names of the scripts of the letters in w,
+// sorted. Characters common to all scripts, such as digits, and
+// combining marks aren't counted.
+func wordScripts(w string) []string {
+	set := map[string]bool{}
+	for _, r := range w {
+		if !unicode.IsLetter(r) || unicode.Is(unicode.Common, r) || unicode.Is(unicode.Inherited, r) {
+			continue
+		}
+		for name, table := range unicode.Scripts {
+			if unicode.Is(table, r) {
+				set[name] = true
+				break
+			}
+		}
+	}
+	scripts := make([]string, 0, len(set))
+	for name := range set {
+		scripts = append(scripts, name)
+	}
+	sort.Strings(scripts)
+	return scripts
+}
+
+// Scripts that are written together.
+var scriptFamilies = [][]string{
+	{"Han", "Hiragana", "Katakana"},
+	{"Han", "Hangul"},
+}
+
+// mixedScripts returns true if a word written in scripts, as returned by
+// wordScripts, mixes scripts that aren't written together.
+func mixedScripts(scripts []string) bool {
+	if len(scripts) < 2 {
+		return false
+	}
+family:
+	for _, family := range scriptFamilies {
+		for _, s := range scripts {
+			if !containsString(family, s) {
+				continue family
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
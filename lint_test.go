@@ -0,0 +1,84 @@
+package codenames
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLintWordPack(t *testing.T) {
+	var words []string
+	for i := 0; i < 25; i++ {
+		words = append(words, fmt.Sprintf("word%c", 'A'+i))
+	}
+	words = append(words,
+		"Fire",
+		"",
+		"FIREMAN",
+		"fire",
+		"ice  cream",
+		"rock'n'roll",
+		"PAРIS",
+		"東京タワー",
+	)
+	problems, err := LintWordPack(strings.NewReader(strings.Join(words, "\n")), "text/plain", "en", 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		"28: FIREMAN: contains FIRE from line 26",
+		"29: fire: duplicates line 26 as FIRE",
+		"30: ice  cream: contains a space",
+		"31: rock'n'roll: contains punctuation '\\''",
+		"32: PAРIS: mixes scripts Cyrillic and Latin",
+		"has 31 distinct words, fewer than the board size of 40",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got problems:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// CSV problems are reported by line.
+	problems, err = LintWordPack(strings.NewReader(strings.Join(words[:25], ", ")+"\nsun, SUNFLOWER\n"), "text/csv", "", 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Line != 2 || problems[0].Word != "SUNFLOWER" {
+		t.Errorf("unexpected problems %v", problems)
+	}
+
+	// The pack's size is only checked against the given board size.
+	for _, tc := range []struct {
+		boardSize int
+		problems  int
+	}{{10, 0}, {16, 1}} {
+		problems, err = LintWordPack(strings.NewReader(strings.Join(words[:15], "\n")), "text/plain", "en", tc.boardSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != tc.problems {
+			t.Errorf("15 words with a board size of %d: problems %v", tc.boardSize, problems)
+		}
+	}
+
+	// The bundled word sets canonicalize cleanly.
+	var ws WordSets
+	if err := ws.LoadDir("assets/wordsets"); err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range ws.List() {
+		words, _ := ws.Named([]string{info.Name})
+		problems, err := LintWordPack(strings.NewReader(strings.Join(words, "\n")), "text/plain", info.Language, wordsPerGame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range problems {
+			if strings.Contains(p.Message, "duplicates") || strings.Contains(p.Message, "mixes scripts") || p.Line == 0 {
+				t.Errorf("word set %q: %s", info.Name, p)
+			}
+		}
+	}
+}
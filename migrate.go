@@ -0,0 +1,115 @@
+package codenames
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// currentSchemaVersion is the schema version written alongside every
+// persisted game. It must always equal len(migrations).
+const currentSchemaVersion = 2
+
+// A migration upgrades a decoded game record from one schema version to
+// the next. Records are passed as a map of top-level JSON fields so that
+// migrations don't depend on the current shape of the Game struct.
+type migration func(record map[string]json.RawMessage) error
+
+// migrations holds the upgrade path for persisted games. migrations[i]
+// upgrades a record from schema version i to version i+1. When changing
+// the persisted representation of a Game, append a migration and bump
+// currentSchemaVersion.
+var migrations = []migration{
+	0: migrateV0ToV1,
+	1: migrateV1ToV2,
+}
+
+func init() {
+	if len(migrations) != currentSchemaVersion {
+		panic(fmt.Sprintf("have %d migrations for schema version %d", len(migrations), currentSchemaVersion))
+	}
+}
+
+// persistedGame is the representation of a game written to the store.
+type persistedGame struct {
+	SchemaVersion int `json:"schema_version"`
+	*Game
+}
+
+// decodeGame decodes a persisted game record, upgrading it to the
+// current schema version if necessary. The returned bool reports
+// whether the record was migrated and should be rewritten.
+func decodeGame(b []byte) (*Game, bool, error) {
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(b, &header); err != nil {
+		return nil, false, err
+	}
+	if header.SchemaVersion > currentSchemaVersion {
+		return nil, false, fmt.Errorf("schema version %d is newer than supported version %d",
+			header.SchemaVersion, currentSchemaVersion)
+	}
+
+	migrated := header.SchemaVersion < currentSchemaVersion
+	if migrated {
+		var record map[string]json.RawMessage
+		if err := json.Unmarshal(b, &record); err != nil {
+			return nil, false, err
+		}
+		for v := header.SchemaVersion; v < currentSchemaVersion; v++ {
+			if err := migrations[v](record); err != nil {
+				return nil, false, fmt.Errorf("migrating from schema version %d: %w", v, err)
+			}
+		}
+		delete(record, "schema_version")
+
+		var err error
+		b, err = json.Marshal(record)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	var g Game
+	if err := json.Unmarshal(b, &g); err != nil {
+		return nil, false, err
+	}
+	return &g, migrated, nil
+}
+
+// migrateV0ToV1 upgrades records written before schema versioning was
+// introduced. Those records may be missing the `revealed` slice if the
+// game was created before it was persisted, so we fill it in.
+func migrateV0ToV1(record map[string]json.RawMessage) error {
+	if _, ok := record["revealed"]; ok && string(record["revealed"]) != "null" {
+		return nil
+	}
+	var layout []json.RawMessage
+	if raw, ok := record["layout"]; ok {
+		if err := json.Unmarshal(raw, &layout); err != nil {
+			return fmt.Errorf("layout: %w", err)
+		}
+	}
+	revealed, err := json.Marshal(make([]bool, len(layout)))
+	if err != nil {
+		return err
+	}
+	record["revealed"] = revealed
+	return nil
+}
+
+// migrateV1ToV2 gives games written before versioning was introduced an
+// initial version. Their state IDs were derived from UpdatedAt instead.
+func migrateV1ToV2(record map[string]json.RawMessage) error {
+	var version uint64
+	if raw, ok := record["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return fmt.Errorf("version: %w", err)
+		}
+	}
+	if version > 0 {
+		return nil
+	}
+	record["version"] = json.RawMessage("1")
+	return nil
+}
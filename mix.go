@@ -0,0 +1,174 @@
+package codenames
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// PackWeight requests a share of each board from a named word set or
+// word pack. Boards draw cards from packs in proportion to their
+// weights, after each pack has been given its minimum number of cards.
+type PackWeight struct {
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight,omitempty"`
+	Min    int     `json:"min,omitempty"`
+}
+
+// PackShare records the cards drawn from one pack of a mixed word set.
+// The pack's words are WordSet[Offset:Offset+Size], and each board has
+// Count of them.
+type PackShare struct {
+	Name   string `json:"name"`
+	Offset int    `json:"offset"`
+	Size   int    `json:"size"`
+	Count  int    `json:"count"`
+}
+
+// Mix composes the requested packs into a single word set, returning it
+// along with the share of each board drawn from each pack. Words that
+// appear in more than one pack belong to the first.
+func (ws *WordSets) Mix(packs []PackWeight) ([]string, []PackShare, error) {
+	if len(packs) == 0 {
+		return nil, nil, nil
+	}
+	var (
+		words   []string
+		shares  []PackShare
+		weights []float64
+		mins    []int
+		seen    = map[string]bool{}
+	)
+	for _, p := range packs {
+		if p.Weight < 0 || p.Min < 0 {
+			return nil, nil, fmt.Errorf("word set %q has a negative weight or minimum", p.Name)
+		}
+		packWords, err := ws.Named([]string{p.Name})
+		if err != nil {
+			return nil, nil, err
+		}
+		share := PackShare{Name: p.Name, Offset: len(words)}
+		for _, w := range packWords {
+			if !seen[w] {
+				seen[w] = true
+				words = append(words, w)
+			}
+		}
+		share.Size = len(words) - share.Offset
+		shares = append(shares, share)
+
+		weight := p.Weight
+		if weight == 0 && p.Min == 0 {
+			// Packs without a weight or a minimum are weighted equally.
+			weight = 1
+		}
+		weights = append(weights, weight)
+		mins = append(mins, p.Min)
+	}
+
+	sizes := make([]int, len(shares))
+	for i, s := range shares {
+		sizes[i] = s.Size
+	}
+	counts, err := allocateCards(wordsPerGame, sizes, weights, mins)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range shares {
+		shares[i].Count = counts[i]
+	}
+	return words, shares, nil
+}
+
+// allocateCards divides total cards between packs. Each pack first gets
+// its minimum, and the remaining cards are handed out one at a time to
+// the pack furthest below its weighted share. No pack is given more
+// cards than it has words.
+func allocateCards(total int, sizes []int, weights []float64, mins []int) ([]int, error) {
+	counts := make([]int, len(sizes))
+	remaining := total
+	var totalWeight float64
+	for i := range sizes {
+		if mins[i] > sizes[i] {
+			return nil, fmt.Errorf("a word set has %d words, fewer than its minimum of %d", sizes[i], mins[i])
+		}
+		counts[i] = mins[i]
+		remaining -= mins[i]
+		totalWeight += weights[i]
+	}
+	if remaining < 0 {
+		return nil, fmt.Errorf("minimums add up to more than %d cards", total)
+	}
+
+	extra := remaining
+	for ; remaining > 0; remaining-- {
+		best := -1
+		var bestDeficit float64
+		for i := range sizes {
+			if counts[i] >= sizes[i] || weights[i] == 0 {
+				continue
+			}
+			deficit := float64(extra)*weights[i]/totalWeight - float64(counts[i]-mins[i])
+			if best == -1 || deficit > bestDeficit {
+				best, bestDeficit = i, deficit
+			}
+		}
+		if best == -1 {
+			return nil, errors.New("the word sets don't have enough words for their shares")
+		}
+		counts[best]++
+	}
+	return counts, nil
+}
+
+// exhausted returns true if the state's permutation doesn't have enough
+// unused words left for another game.
+func (gs GameState) exhausted() bool {
+	if len(gs.Mix) == 0 {
+		return gs.PermIndex+wordsPerGame >= len(gs.WordSet)
+	}
+	// Each pack is walked separately, Count words per game.
+	game := gs.PermIndex / wordsPerGame
+	for _, share := range gs.Mix {
+		if (game+1)*share.Count > share.Size {
+			return true
+		}
+	}
+	return false
+}
+
+// mixedWords picks the words of a board from a mixed word set. Each pack
+// has its own permutation, seeded from seedRnd, and each game takes the
+// next Count words from it.
+func (gs GameState) mixedWords(seedRnd *rand.Rand) []string {
+	game := gs.PermIndex / wordsPerGame
+	deferred := gs.deferredSet()
+	words := make([]string, 0, wordsPerGame)
+	for _, share := range gs.Mix {
+		packRnd := rand.New(rand.NewSource(seedRnd.Int63()))
+		packWords := gs.WordSet[share.Offset : share.Offset+share.Size]
+		perm := deferLast(packRnd.Perm(share.Size), packWords, deferred)
+		for _, i := range perm[game*share.Count : (game+1)*share.Count] {
+			words = append(words, packWords[i])
+		}
+	}
+	return words
+}
+
+// validMix returns an error if the mix doesn't describe boards that can
+// be drawn from the state's word set.
+func (gs GameState) validMix() error {
+	var count int
+	game := gs.PermIndex / wordsPerGame
+	for _, share := range gs.Mix {
+		if share.Offset < 0 || share.Size < 0 || share.Count < 0 ||
+			share.Offset+share.Size > len(gs.WordSet) || (game+1)*share.Count > share.Size {
+			return fmt.Errorf("word set %q doesn't have enough words", share.Name)
+		}
+		count += share.Count
+	}
+	if len(gs.Mix) > 0 && count != wordsPerGame {
+		return fmt.Errorf("mix has %d cards, expected %d", count, wordsPerGame)
+	}
+	return nil
+}
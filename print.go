@@ -0,0 +1,434 @@
+package codenames
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Printed sheets are US Letter in landscape, in points.
+const (
+	pageWidth  = 792
+	pageHeight = 612
+	pageMargin = 36
+)
+
+// maxPrintBoards is the most boards that can be printed in one batch.
+const maxPrintBoards = 50
+
+// Sheets that can be printed for each board.
+const (
+	SheetBoard = "board" // the word grid for operatives
+	SheetKey   = "key"   // the key card for spymasters
+	SheetBoth  = "both"
+)
+
+// Colors of printed cards, matching the game's.
+var printColors = map[Team]string{
+	Red:     "#d13030",
+	Blue:    "#4183cc",
+	Neutral: "#ede2cc",
+	Black:   "#222222",
+}
+
+// printMarks are printed on key cards so that they can be read in black
+// and white, or without telling red from blue.
+var printMarks = map[Team]string{
+	Red:   "R",
+	Blue:  "B",
+	Black: "X",
+}
+
+// printSheet is a page of rectangles and text, laid out once and
+// rendered as either SVG or PDF. Coordinates are in points from the top
+// left corner of the page.
+type printSheet struct {
+	rects []printRect
+	texts []printText
+}
+
+type printRect struct {
+	x, y, w, h  float64
+	fill        string // empty for none
+	stroke      string // empty for none
+	strokeWidth float64
+}
+
+// printText is a line of text centered on x, with its baseline at y.
+type printText struct {
+	x, y, size float64
+	text       string
+	fill       string
+}
+
+func (s *printSheet) rect(r printRect) { s.rects = append(s.rects, r) }
+
+func (s *printSheet) text(t printText) { s.texts = append(s.texts, t) }
+
+// boardSheet lays out the words of a board in a grid for operatives.
+func boardSheet(g *Game, label string) printSheet {
+	var s printSheet
+	s.text(printText{x: pageWidth / 2, y: pageMargin + 16, size: 16, text: label, fill: "#000000"})
+
+	const gap = 10
+	top := float64(pageMargin + 36)
+	cardW := (pageWidth - 2*pageMargin - 4*gap) / 5.0
+	cardH := (pageHeight - pageMargin - top - 4*gap) / 5.0
+	for i, w := range g.Words {
+		x := pageMargin + float64(i%5)*(cardW+gap)
+		y := top + float64(i/5)*(cardH+gap)
+		s.rect(printRect{x: x, y: y, w: cardW, h: cardH, fill: "#ffffff", stroke: "#999999", strokeWidth: 1})
+		// Long words are shrunk to fit their cards.
+		size := 22.0
+		if width := textWidth(w); width > 0 && (cardW-16)*1000/width < size {
+			size = (cardW - 16) * 1000 / width
+		}
+		s.text(printText{x: x + cardW/2, y: y + cardH/2 + size*0.35, size: size, text: w, fill: "#000000"})
+	}
+	return s
+}
+
+// keySheet lays out the key card of a board for spymasters, framed in
+// the color of the team that goes first.
+func keySheet(g *Game, label string) printSheet {
+	var s printSheet
+	s.text(printText{
+		x: pageWidth / 2, y: pageMargin + 16, size: 16, fill: "#000000",
+		text: fmt.Sprintf("%s (%s goes first)", label, g.StartingTeam),
+	})
+
+	const cell, gap, padding, frame = 64.0, 8.0, 16.0, 14.0
+	grid := 5*cell + 4*gap
+	left := (pageWidth - grid) / 2
+	top := float64(pageMargin + 60)
+	s.rect(printRect{
+		x: left - padding, y: top - padding, w: grid + 2*padding, h: grid + 2*padding,
+		stroke: printColors[g.StartingTeam], strokeWidth: frame,
+	})
+	counts := map[Team]int{}
+	for i, team := range g.Layout {
+		counts[team]++
+		x := left + float64(i%5)*(cell+gap)
+		y := top + float64(i/5)*(cell+gap)
+		s.rect(printRect{x: x, y: y, w: cell, h: cell, fill: printColors[team], stroke: "#666666", strokeWidth: 0.5})
+		if mark := printMarks[team]; mark != "" {
+			s.text(printText{x: x + cell/2, y: y + cell/2 + 8, size: 24, text: mark, fill: "#ffffff"})
+		}
+	}
+	s.text(printText{
+		x: pageWidth / 2, y: top + grid + padding + 40, size: 12, fill: "#000000",
+		text: fmt.Sprintf("Red %d, blue %d, neutral %d, assassin %d", counts[Red], counts[Blue], counts[Neutral], counts[Black]),
+	})
+	return s
+}
+
+// helveticaWidths are the widths of the printable ASCII characters in
+// Helvetica, in thousandths of the font size.
+var helveticaWidths = [...]float64{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// textWidth approximates the width of s in Helvetica, in thousandths of
+// the font size. Characters outside ASCII are counted as the width of a
+// typical capital letter.
+func textWidth(s string) float64 {
+	var width float64
+	for _, r := range s {
+		if r >= ' ' && r <= '~' {
+			width += helveticaWidths[r-' ']
+		} else {
+			width += 722
+		}
+	}
+	return width
+}
+
+// writeSVG renders sheets as a single SVG, one page below another.
+func writeSVG(w io.Writer, sheets []printSheet) error {
+	const gap = 24
+	height := len(sheets)*pageHeight + (len(sheets)-1)*gap
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%dpt" height="%dpt" viewBox="0 0 %d %d" font-family="Helvetica, Arial, sans-serif">`+"\n",
+		pageWidth, height, pageWidth, height)
+	for i, s := range sheets {
+		fmt.Fprintf(&b, `<g transform="translate(0 %d)">`+"\n", i*(pageHeight+gap))
+		fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#ffffff"/>`+"\n", pageWidth, pageHeight)
+		for _, r := range s.rects {
+			fill, stroke := r.fill, r.stroke
+			if fill == "" {
+				fill = "none"
+			}
+			if stroke == "" {
+				stroke = "none"
+			}
+			fmt.Fprintf(&b, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="%s" stroke="%s" stroke-width="%.2f"/>`+"\n",
+				r.x, r.y, r.w, r.h, fill, stroke, r.strokeWidth)
+		}
+		for _, t := range s.texts {
+			fmt.Fprintf(&b, `<text x="%.2f" y="%.2f" font-size="%.2f" fill="%s" text-anchor="middle">`, t.x, t.y, t.size, t.fill)
+			xml.EscapeText(&b, []byte(t.text))
+			b.WriteString("</text>\n")
+		}
+		b.WriteString("</g>\n")
+	}
+	b.WriteString("</svg>\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// pdfPrintable returns whether all the text of sheets can be set in the
+// font of writePDF.
+func pdfPrintable(sheets []printSheet) bool {
+	encoder := charmap.Windows1252.NewEncoder()
+	for _, s := range sheets {
+		for _, t := range s.texts {
+			if _, err := encoder.String(t.text); err != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// writePDF renders sheets as a PDF, one sheet per page. Text is set in
+// the standard Helvetica font, so it's limited to the characters of the
+// Windows-1252 encoding.
+func writePDF(w io.Writer, sheets []printSheet) error {
+	// Objects 1 to 3 are the catalog, the page tree and the font,
+	// followed by a page and its contents for each sheet.
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+	var kids []string
+	encoder := charmap.Windows1252.NewEncoder()
+	for _, s := range sheets {
+		var content bytes.Buffer
+		for _, r := range s.rects {
+			// Colors are set before the path, which must be painted as
+			// soon as it's constructed.
+			paint := "S"
+			switch {
+			case r.fill != "" && r.stroke != "":
+				fmt.Fprintf(&content, "%s rg %s RG %.2f w ", pdfColor(r.fill), pdfColor(r.stroke), r.strokeWidth)
+				paint = "B"
+			case r.fill != "":
+				fmt.Fprintf(&content, "%s rg ", pdfColor(r.fill))
+				paint = "f"
+			default:
+				fmt.Fprintf(&content, "%s RG %.2f w ", pdfColor(r.stroke), r.strokeWidth)
+			}
+			fmt.Fprintf(&content, "%.2f %.2f %.2f %.2f re %s\n", r.x, pageHeight-r.y-r.h, r.w, r.h, paint)
+		}
+		for _, t := range s.texts {
+			encoded, err := encoder.String(t.text)
+			if err != nil {
+				return fmt.Errorf("%q can't be printed as a PDF; print it as an SVG instead", t.text)
+			}
+			x := t.x - textWidth(t.text)*t.size/2000
+			fmt.Fprintf(&content, "BT /F1 %.2f Tf %s rg %.2f %.2f Td (%s) Tj ET\n",
+				t.size, pdfColor(t.fill), x, pageHeight-t.y, pdfEscape(encoded))
+		}
+		page := len(objects) + 1
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				pageWidth, pageHeight, page+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		)
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	var b bytes.Buffer
+	// The comment of binary characters marks the file as binary.
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// pdfColor converts a color like "#d13030" to PDF RGB components.
+func pdfColor(hex string) string {
+	v, _ := strconv.ParseUint(strings.TrimPrefix(hex, "#"), 16, 32)
+	return fmt.Sprintf("%.3f %.3f %.3f", float64(v>>16&0xFF)/255, float64(v>>8&0xFF)/255, float64(v&0xFF)/255)
+}
+
+func pdfEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`).Replace(s)
+}
+
+// printBatch returns count boards of words, in the order a game started
+// with seed would deal them. Unlike nextGameState, it reseeds from seed
+// once the words run out, so that a seed always prints the same boards.
+func printBatch(words []string, seed int64, count int, clock Clock) []*Game {
+	reseed := rand.New(rand.NewSource(seed))
+	state := GameState{Seed: seed, Revealed: make([]bool, wordsPerGame), WordSet: words}
+	games := make([]*Game, count)
+	for i := range games {
+		games[i] = newGame(fmt.Sprintf("board-%d", i+1), state, GameOptions{}, clock)
+		state.PermIndex += wordsPerGame
+		if state.exhausted() {
+			state.Seed = reseed.Int63()
+			state.PermIndex = 0
+		}
+	}
+	return games
+}
+
+// GET /print?game_id=<id>[&sheet=board|key|both][&format=pdf|svg]
+// GET /print?seed=<n>[&count=<n>][&word_sets=<names>][&language=<tag>][&sheet=...][&format=...]
+//
+// Renders boards for playing in person: the word grid for operatives and
+// the key card for spymasters, as a PDF with a page per sheet, or an SVG
+// with the sheets one below another. Without a format, boards whose words
+// can't be set in the PDF's font are printed as SVGs. Either an existing game is printed,
+// or count boards are generated from seed and the comma-separated word
+// sets, or the default words. The same seed and words always generate
+// the same boards.
+func (s *Server) handlePrint(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	sheet := query.Get("sheet")
+	switch sheet {
+	case "":
+		sheet = SheetBoth
+	case SheetBoard, SheetKey, SheetBoth:
+	default:
+		http.Error(rw, fmt.Sprintf("unknown sheet %q", sheet), 400)
+		return
+	}
+
+	var games []*Game
+	var labels []string
+	if id := query.Get("game_id"); id != "" {
+		g, ok := s.printableGame(id)
+		if !ok {
+			http.NotFound(rw, req)
+			return
+		}
+		games, labels = []*Game{g}, []string{"Codenames: " + id}
+	} else {
+		var err error
+		games, err = s.printBatchGames(query.Get("seed"), query.Get("count"), query.Get("word_sets"), query.Get("language"))
+		if err != nil {
+			http.Error(rw, err.Error(), 400)
+			return
+		}
+		for i := range games {
+			labels = append(labels, fmt.Sprintf("Board %d of %d, seed %s", i+1, len(games), query.Get("seed")))
+		}
+	}
+
+	var sheets []printSheet
+	for i, g := range games {
+		if sheet != SheetKey {
+			sheets = append(sheets, boardSheet(g, labels[i]))
+		}
+		if sheet != SheetBoard {
+			sheets = append(sheets, keySheet(g, labels[i]))
+		}
+	}
+
+	var buf bytes.Buffer
+	var err error
+	format := query.Get("format")
+	if format == "" {
+		format = "pdf"
+		if !pdfPrintable(sheets) {
+			format = "svg"
+		}
+	}
+	switch format {
+	case "pdf":
+		rw.Header().Set("Content-Type", "application/pdf")
+		err = writePDF(&buf, sheets)
+	case "svg":
+		rw.Header().Set("Content-Type", "image/svg+xml")
+		err = writeSVG(&buf, sheets)
+	default:
+		http.Error(rw, fmt.Sprintf("unknown format %q", format), 400)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	rw.Write(buf.Bytes())
+}
+
+// printableGame returns a copy of the board of an existing game, without
+// creating the game if it doesn't exist.
+func (s *Server) printableGame(id string) (*Game, bool) {
+	s.mu.Lock()
+	gh, ok := s.games[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	return &Game{
+		ID:           gh.g.ID,
+		StartingTeam: gh.g.StartingTeam,
+		Words:        append([]string(nil), gh.g.Words...),
+		Layout:       append([]Team(nil), gh.g.Layout...),
+	}, true
+}
+
+// printBatchGames parses the parameters of a batch of boards and
+// generates them.
+func (s *Server) printBatchGames(seedParam, countParam, wordSetsParam, lang string) ([]*Game, error) {
+	if seedParam == "" {
+		return nil, errors.New("either game_id or seed is required")
+	}
+	seed, err := strconv.ParseInt(seedParam, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seed %q", seedParam)
+	}
+	count := 1
+	if countParam != "" {
+		count, err = strconv.Atoi(countParam)
+		if err != nil || count < 1 || count > maxPrintBoards {
+			return nil, fmt.Errorf("count must be between 1 and %d", maxPrintBoards)
+		}
+	}
+
+	words := s.defaultWords
+	if wordSetsParam != "" {
+		names := strings.Split(wordSetsParam, ",")
+		named, err := s.wordSets.Named(names)
+		if err != nil {
+			return nil, err
+		}
+		if lang == "" {
+			lang = s.wordSets.Language(names)
+		}
+		if words, err = normalizeWordSet(named, lang); err != nil {
+			return nil, err
+		}
+	}
+	return printBatch(words, seed, count, s.Clock), nil
+}
@@ -0,0 +1,150 @@
+package codenames
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPrintBatch(t *testing.T) {
+	// 400 words make 15 boards before reseeding.
+	a := printBatch(testWords, 42, 20, SystemClock)
+	b := printBatch(testWords, 42, 20, SystemClock)
+	for i := range a {
+		if !equalWords(a[i].Words, b[i].Words) || a[i].StartingTeam != b[i].StartingTeam {
+			t.Fatalf("board %d differs between batches from the same seed", i+1)
+		}
+		for j, team := range a[i].Layout {
+			if b[i].Layout[j] != team {
+				t.Fatalf("board %d's layout differs between batches from the same seed", i+1)
+			}
+		}
+	}
+	seen := map[string]bool{}
+	for _, g := range a[:15] {
+		for _, w := range g.Words {
+			if seen[w] {
+				t.Errorf("%q is on more than one board before the words run out", w)
+			}
+			seen[w] = true
+		}
+	}
+	if c := printBatch(testWords, 43, 1, SystemClock); equalWords(c[0].Words, a[0].Words) {
+		t.Error("different seeds printed the same board")
+	}
+}
+
+// checkPDF checks that the cross-reference table of a PDF points at its
+// objects, and returns the number of pages.
+func checkPDF(t *testing.T, pdf []byte) int {
+	t.Helper()
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("not a PDF")
+	}
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(pdf[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d doesn't point at the xref table", xref)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(pdf[xref:], -1)
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(pdf[off:], []byte(want)) {
+			t.Errorf("object %d isn't at offset %d", i+1, off)
+		}
+	}
+	m = regexp.MustCompile(`/Count (\d+)`).FindSubmatch(pdf)
+	pages, _ := strconv.Atoi(string(m[1]))
+	return pages
+}
+
+func TestPrintGame(t *testing.T) {
+	s := newTestServer(nil)
+	g := newGame("friday-night", randomState(testWords), GameOptions{}, SystemClock)
+	s.games[g.ID] = s.newGameHandle(g)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handlePrint(rec, httptest.NewRequest("GET", "/print?"+query, nil))
+		return rec
+	}
+
+	rec := get("game_id=friday-night")
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "application/pdf" {
+		t.Fatalf("status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	pdf := rec.Body.Bytes()
+	if pages := checkPDF(t, pdf); pages != 2 {
+		t.Errorf("%d pages, expected a board and a key card", pages)
+	}
+	for _, w := range g.Words {
+		if !bytes.Contains(pdf, []byte("("+w+")")) {
+			t.Errorf("PDF is missing %q", w)
+		}
+	}
+
+	rec = get("game_id=friday-night&format=svg&sheet=key")
+	svg := rec.Body.String()
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if strings.Contains(svg, g.Words[0]) {
+		t.Error("key card has words on it")
+	}
+	frame := fmt.Sprintf(`fill="none" stroke="%s"`, printColors[g.StartingTeam])
+	if !strings.Contains(svg, frame) || !strings.Contains(svg, g.StartingTeam.String()+" goes first") {
+		t.Error("key card isn't framed in the starting team's color")
+	}
+
+	rec = get("seed=7&count=3&sheet=board")
+	if rec.Code != 200 {
+		t.Fatalf("printing a batch: status %d: %s", rec.Code, rec.Body)
+	}
+	if pages := checkPDF(t, rec.Body.Bytes()); pages != 3 {
+		t.Errorf("%d pages, expected 3 boards", pages)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), get("seed=7&count=3&sheet=board").Body.Bytes()) {
+		t.Error("the same seed printed different boards")
+	}
+
+	if code := get("game_id=saturday-night").Code; code != 404 {
+		t.Errorf("printing a missing game: status %d, expected 404", code)
+	}
+	for _, query := range []string{
+		"",
+		"seed=seven",
+		"seed=7&count=500",
+		"seed=7&word_sets=nonexistent",
+		"game_id=friday-night&sheet=cards",
+		"game_id=friday-night&format=docx",
+	} {
+		if code := get(query).Code; code != 400 {
+			t.Errorf("%q: status %d, expected 400", query, code)
+		}
+	}
+
+	// Words outside Windows-1252 can't be set in the PDF's font, so they
+	// are printed as SVGs unless a PDF is asked for.
+	var greek []string
+	for i := 0; i < wordsPerGame; i++ {
+		greek = append(greek, fmt.Sprintf("ΛΕΞΗ %d", i))
+	}
+	g = newGame("greek-night", randomState(greek), GameOptions{}, SystemClock)
+	s.games[g.ID] = s.newGameHandle(g)
+	if code := get("game_id=greek-night&format=pdf").Code; code != 400 {
+		t.Errorf("printing Greek words as a PDF: status %d, expected 400", code)
+	}
+	for _, query := range []string{"game_id=greek-night&format=svg", "game_id=greek-night"} {
+		rec := get(query)
+		if rec.Code != 200 || rec.Header().Get("Content-Type") != "image/svg+xml" || !strings.Contains(rec.Body.String(), "ΛΕΞΗ") {
+			t.Errorf("%q: status %d, content type %q", query, rec.Code, rec.Header().Get("Content-Type"))
+		}
+	}
+}
@@ -0,0 +1,518 @@
+package codenames
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// qrCode is an encoded QR code: a square of dark and light modules.
+// Codes are encoded in byte mode at error correction level M, in
+// versions 1 to 10, which hold up to 213 bytes: plenty for a link.
+type qrCode struct {
+	version int
+	size    int
+	modules [][]bool // dark modules, by row then column
+	// function marks finder, timing and alignment patterns, and the
+	// format and version areas, which hold no data and aren't masked.
+	function [][]bool
+}
+
+// qrQuietZone is the width, in modules, of the light border scanners
+// need around a code.
+const qrQuietZone = 4
+
+// qrBlocks describes how the codewords of a version are split into
+// blocks at error correction level M.
+type qrBlocks struct {
+	ecPerBlock int
+	// groups are the number of blocks and data codewords per block.
+	groups [][2]int
+}
+
+// qrVersions are the block structures of versions 1 to 10, indexed by
+// version - 1.
+var qrVersions = []qrBlocks{
+	{10, [][2]int{{1, 16}}},
+	{16, [][2]int{{1, 28}}},
+	{26, [][2]int{{1, 44}}},
+	{18, [][2]int{{2, 32}}},
+	{24, [][2]int{{2, 43}}},
+	{16, [][2]int{{4, 27}}},
+	{18, [][2]int{{4, 31}}},
+	{22, [][2]int{{2, 38}, {2, 39}}},
+	{22, [][2]int{{3, 36}, {2, 37}}},
+	{26, [][2]int{{4, 43}, {1, 44}}},
+}
+
+// qrAlignment are the centers of alignment patterns, in both directions,
+// indexed by version - 1.
+var qrAlignment = [][]int{
+	nil,
+	{6, 18},
+	{6, 22},
+	{6, 26},
+	{6, 30},
+	{6, 34},
+	{6, 22, 38},
+	{6, 24, 42},
+	{6, 26, 46},
+	{6, 28, 50},
+}
+
+func (b qrBlocks) dataCodewords() int {
+	var n int
+	for _, g := range b.groups {
+		n += g[0] * g[1]
+	}
+	return n
+}
+
+// encodeQR encodes data in the smallest version that holds it, with the
+// mask that makes it easiest to scan.
+func encodeQR(data []byte) (*qrCode, error) {
+	version := 0
+	for v := 1; v <= len(qrVersions); v++ {
+		// The header is a 4 bit mode and the length, which is 8 bits
+		// before version 10 and 16 bits from it.
+		header := 12
+		if v >= 10 {
+			header = 20
+		}
+		if header+8*len(data) <= 8*qrVersions[v-1].dataCodewords() {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("%d bytes is too long for a QR code", len(data))
+	}
+
+	size := 17 + 4*version
+	q := &qrCode{version: version, size: size}
+	q.modules = make([][]bool, size)
+	q.function = make([][]bool, size)
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+	q.drawFunctionPatterns()
+	q.drawCodewords(qrCodewords(version, data))
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		// Masks are their own inverse.
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+	return q, nil
+}
+
+// qrCodewords returns data encoded in byte mode, padded, split into
+// blocks with error correction codewords, and interleaved.
+func qrCodewords(version int, data []byte) []byte {
+	blocks := qrVersions[version-1]
+	capacity := blocks.dataCodewords()
+
+	var bits qrBits
+	bits.append(0x4, 4)
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	// A terminator of up to four zeros, then zeros to a whole byte.
+	for i := 0; i < 4 && bits.n < capacity*8; i++ {
+		bits.append(0, 1)
+	}
+	for bits.n%8 != 0 {
+		bits.append(0, 1)
+	}
+	for pad := 0xEC; len(bits.bytes) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	divisor := rsDivisor(blocks.ecPerBlock)
+	var dataBlocks, ecBlocks [][]byte
+	rest := bits.bytes
+	for _, g := range blocks.groups {
+		for i := 0; i < g[0]; i++ {
+			dataBlocks = append(dataBlocks, rest[:g[1]])
+			ecBlocks = append(ecBlocks, rsRemainder(rest[:g[1]], divisor))
+			rest = rest[g[1]:]
+		}
+	}
+
+	var out []byte
+	longest := blocks.groups[len(blocks.groups)-1][1]
+	for i := 0; i < longest; i++ {
+		for _, b := range dataBlocks {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := 0; i < blocks.ecPerBlock; i++ {
+		for _, b := range ecBlocks {
+			out = append(out, b[i])
+		}
+	}
+	return out
+}
+
+// qrBits is a sequence of bits, most significant first.
+type qrBits struct {
+	bytes []byte
+	n     int
+}
+
+func (b *qrBits) append(v, length int) {
+	for i := length - 1; i >= 0; i-- {
+		if b.n%8 == 0 {
+			b.bytes = append(b.bytes, 0)
+		}
+		if v>>uint(i)&1 != 0 {
+			b.bytes[len(b.bytes)-1] |= 0x80 >> uint(b.n%8)
+		}
+		b.n++
+	}
+}
+
+// gfMultiply multiplies in GF(256) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>uint(i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsDivisor returns the coefficients, highest first and excluding the
+// leading 1, of the Reed-Solomon generator polynomial of a degree.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < degree {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 2)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+func (q *qrCode) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+func (q *qrCode) drawFunctionPatterns() {
+	for i := 0; i < q.size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	for _, c := range [][2]int{{3, 3}, {q.size - 4, 3}, {3, q.size - 4}} {
+		// A finder pattern and its separator.
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || x >= q.size || y < 0 || y >= q.size {
+					continue
+				}
+				d := maxInt(absInt(dx), absInt(dy))
+				q.set(x, y, d != 2 && d != 4)
+			}
+		}
+	}
+	centers := qrAlignment[q.version-1]
+	last := len(centers) - 1
+	for i, cx := range centers {
+		for j, cy := range centers {
+			// Skip the corners taken by finder patterns.
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(cx+dx, cy+dy, maxInt(absInt(dx), absInt(dy)) != 1)
+				}
+			}
+		}
+	}
+	// Reserve the format areas until a mask is chosen.
+	q.drawFormat(0)
+	if q.version >= 7 {
+		q.drawVersion()
+	}
+}
+
+// drawFormat draws both copies of the format information: the error
+// correction level and mask, with BCH error correction.
+func (q *qrCode) drawFormat(mask int) {
+	bits := qrFormatBits(mask)
+	bit := func(i int) bool { return bits>>uint(i)&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	// The dark module.
+	q.set(8, q.size-8, true)
+}
+
+// qrFormatBits returns the 15 bits of format information for a mask at
+// error correction level M.
+func qrFormatBits(mask int) int {
+	// Level M is 00.
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawVersion draws both copies of the version, with BCH error
+// correction, which versions 7 and up carry.
+func (q *qrCode) drawVersion() {
+	rem := q.version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	bits := q.version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := bits>>uint(i)&1 != 0
+		a, b := q.size-11+i%3, i/3
+		q.set(a, b, dark)
+		q.set(b, a, dark)
+	}
+}
+
+// drawCodewords fills the modules that aren't function patterns with
+// data, in pairs of columns zigzagging up and down from the right.
+func (q *qrCode) drawCodewords(data []byte) {
+	var i int
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// Skip the vertical timing pattern.
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			y := vert
+			if upward {
+				y = q.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if q.function[y][x] || i >= len(data)*8 {
+					continue
+				}
+				q.modules[y][x] = data[i/8]>>uint(7-i%8)&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.function[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			q.modules[y][x] = q.modules[y][x] != invert
+		}
+	}
+}
+
+// qrFinderLike are the patterns, dark module by module, that are
+// penalized for looking like finder patterns.
+var qrFinderLike = [][]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+// penalty scores how hard the code is to scan, by the rules of the QR
+// code specification: long runs of one color, 2x2 blocks of one color,
+// patterns that look like finder patterns, and imbalance between dark
+// and light.
+func (q *qrCode) penalty() int {
+	var p, dark int
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < q.size; y++ {
+			run := 1
+			for x := 1; x <= q.size; x++ {
+				if x < q.size && at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					p += run - 2
+				}
+				run = 1
+			}
+			for x := 0; x+11 <= q.size; x++ {
+			pattern:
+				for _, pattern := range qrFinderLike {
+					for i, d := range pattern {
+						if at(x+i, y, transpose) != d {
+							continue pattern
+						}
+					}
+					p += 40
+				}
+			}
+		}
+	}
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := q.modules[y][x]
+				if q.modules[y-1][x] == c && q.modules[y][x-1] == c && q.modules[y-1][x-1] == c {
+					p += 3
+				}
+			}
+		}
+	}
+	total := q.size * q.size
+	// 10 points for each 5% away from an even balance.
+	p += absInt(dark*20-total*10) / total * 10
+	return p
+}
+
+// WritePNG writes the code as a PNG, with each module scale pixels
+// square, including the quiet zone.
+func (q *qrCode) WritePNG(w io.Writer, scale int) error {
+	if scale < 1 {
+		return errors.New("scale must be positive")
+	}
+	side := (q.size + 2*qrQuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if !q.modules[y][x] {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				for px := 0; px < scale; px++ {
+					img.SetColorIndex((x+qrQuietZone)*scale+px, (y+qrQuietZone)*scale+py, 1)
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// WriteSVG writes the code as an SVG, with each module scale user units
+// square, including the quiet zone. Runs of dark modules in a row are
+// drawn as one rectangle, as a single path.
+func (q *qrCode) WriteSVG(w io.Writer, scale int) error {
+	if scale < 1 {
+		return errors.New("scale must be positive")
+	}
+	side := q.size + 2*qrQuietZone
+	var path strings.Builder
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; {
+			if !q.modules[y][x] {
+				x++
+				continue
+			}
+			start := x
+			for x < q.size && q.modules[y][x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start+qrQuietZone, y+qrQuietZone, x-start, x-start)
+		}
+	}
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`+"\n",
+		side*scale, side*scale, side, side, path.String())
+	return err
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func maxInt(x, y int) int {
+	if x > y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,226 @@
+package codenames
+
+import (
+	"bytes"
+	"image/png"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// The worked example of "HELLO WORLD" as a 1-M code.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("error correction codewords %v, expected %v", got, want)
+	}
+}
+
+func TestQRFormatBits(t *testing.T) {
+	for mask, want := range []int{
+		0x5412, // 101010000010010
+		0x5125, // 101000100100101
+		0x5E7C, // 101111001111100
+		0x5B4B, // 101101101001011
+		0x45F9, // 100010111111001
+		0x40CE, // 100000011001110
+		0x4F97, // 100111110010111
+		0x4AA0, // 100101010100000
+	} {
+		if got := qrFormatBits(mask); got != want {
+			t.Errorf("format bits of mask %d: %015b, expected %015b", mask, got, want)
+		}
+	}
+}
+
+// decodeQR reads the data back out of a code, as a scanner would once it
+// has located the modules.
+func decodeQR(t *testing.T, q *qrCode) []byte {
+	t.Helper()
+	// Read the format information next to the top left finder pattern.
+	var format int
+	for i := 0; i <= 5; i++ {
+		if q.modules[i][8] {
+			format |= 1 << uint(i)
+		}
+	}
+	for i, m := range []bool{q.modules[7][8], q.modules[8][8], q.modules[8][7]} {
+		if m {
+			format |= 1 << uint(6+i)
+		}
+	}
+	for i := 9; i < 15; i++ {
+		if q.modules[8][14-i] {
+			format |= 1 << uint(i)
+		}
+	}
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if qrFormatBits(m) == format {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("invalid format information %015b", format)
+	}
+
+	// Unmask a copy, then read the codewords in placement order.
+	blank := &qrCode{version: q.version, size: q.size}
+	blank.modules = make([][]bool, q.size)
+	blank.function = make([][]bool, q.size)
+	for y := range blank.modules {
+		blank.modules[y] = make([]bool, q.size)
+		blank.function[y] = make([]bool, q.size)
+	}
+	blank.drawFunctionPatterns()
+	for y := range q.modules {
+		copy(blank.modules[y], q.modules[y])
+	}
+	blank.applyMask(mask)
+
+	var bits qrBits
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = q.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				if !blank.function[y][right-j] {
+					v := 0
+					if blank.modules[y][right-j] {
+						v = 1
+					}
+					bits.append(v, 1)
+				}
+			}
+		}
+	}
+
+	// Deinterleave the data codewords and check the error correction.
+	blocks := qrVersions[q.version-1]
+	var sizes []int
+	for _, g := range blocks.groups {
+		for i := 0; i < g[0]; i++ {
+			sizes = append(sizes, g[1])
+		}
+	}
+	dataBlocks := make([][]byte, len(sizes))
+	codewords := bits.bytes
+	for i := 0; i < sizes[len(sizes)-1]; i++ {
+		for b, size := range sizes {
+			if i < size {
+				dataBlocks[b] = append(dataBlocks[b], codewords[0])
+				codewords = codewords[1:]
+			}
+		}
+	}
+	for i := 0; i < blocks.ecPerBlock; i++ {
+		for b := range dataBlocks {
+			if want := rsRemainder(dataBlocks[b], rsDivisor(blocks.ecPerBlock))[i]; codewords[0] != want {
+				t.Fatalf("block %d error correction codeword %d is %d, expected %d", b, i, codewords[0], want)
+			}
+			codewords = codewords[1:]
+		}
+	}
+
+	data := bytes.Join(dataBlocks, nil)
+	if data[0]>>4 != 0x4 {
+		t.Fatalf("mode %x, expected byte mode", data[0]>>4)
+	}
+	if q.version >= 10 {
+		n := int(data[0]&0xF)<<12 | int(data[1])<<4 | int(data[2])>>4
+		return shiftNibble(data[2:], n)
+	}
+	n := int(data[0]&0xF)<<4 | int(data[1])>>4
+	return shiftNibble(data[1:], n)
+}
+
+// shiftNibble returns n bytes read starting from the low nibble of b[0].
+func shiftNibble(b []byte, n int) []byte {
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = b[i]<<4 | b[i+1]>>4
+	}
+	return out
+}
+
+func TestEncodeQR(t *testing.T) {
+	for _, tc := range []struct {
+		data    string
+		version int
+	}{
+		{data: "hello", version: 1},
+		{data: "https://codenames.example/friday-night", version: 3},
+		{data: "https://codenames.example/friday-night?role=spymaster&token=" + strings.Repeat("x", 43), version: 6},
+		{data: strings.Repeat("long", 50), version: 10},
+	} {
+		q, err := encodeQR([]byte(tc.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.version != tc.version || q.size != 17+4*tc.version {
+			t.Errorf("%q: version %d, expected %d", tc.data, q.version, tc.version)
+		}
+		// Finder patterns are in three corners.
+		for _, c := range [][2]int{{0, 0}, {q.size - 7, 0}, {0, q.size - 7}} {
+			if !q.modules[c[1]][c[0]] || q.modules[c[1]+1][c[0]+1] || !q.modules[c[1]+3][c[0]+3] {
+				t.Errorf("%q: no finder pattern at %v", tc.data, c)
+			}
+		}
+		if got := decodeQR(t, q); string(got) != tc.data {
+			t.Errorf("decoded %q, expected %q", got, tc.data)
+		}
+	}
+	if _, err := encodeQR(bytes.Repeat([]byte("x"), 214)); err == nil {
+		t.Error("expected an error for data too long for a code")
+	}
+}
+
+func TestRoomQR(t *testing.T) {
+	s := newTestServer(nil)
+
+	req := httptest.NewRequest("GET", "/room-qr?room_id=friday-night&role=spymaster&token=abc_123&scale=2", nil)
+	req.Host = "codenames.example"
+	rec := httptest.NewRecorder()
+	s.handleRoomQR(rec, req)
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	img, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "http://codenames.example/friday-night?role=spymaster&token=abc_123"
+	q, _ := encodeQR([]byte(want))
+	if side := img.Bounds().Dx(); side != 2*(q.size+2*qrQuietZone) {
+		t.Errorf("image is %d pixels wide", side)
+	}
+	if s.joinURL(req, "friday-night", RoleSpymaster, "abc_123") != want {
+		t.Errorf("join URL %q", s.joinURL(req, "friday-night", RoleSpymaster, "abc_123"))
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleRoomQR(rec, httptest.NewRequest("GET", "/room-qr?room_id=friday-night&format=svg", nil))
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "image/svg+xml" || !strings.HasPrefix(rec.Body.String(), "<svg") {
+		t.Errorf("status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	for _, query := range []string{
+		"room_id=Friday%20Night",
+		"room_id=friday-night&role=referee",
+		"room_id=friday-night&token=not%20a%20token",
+		"room_id=friday-night&format=gif",
+		"room_id=friday-night&scale=100",
+	} {
+		rec := httptest.NewRecorder()
+		s.handleRoomQR(rec, httptest.NewRequest("GET", "/room-qr?"+query, nil))
+		if rec.Code != 400 {
+			t.Errorf("%s: status %d, expected 400", query, rec.Code)
+		}
+	}
+}
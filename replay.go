@@ -0,0 +1,154 @@
+package codenames
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Replay reconstructs every intermediate state of a game by creating it
+// at createdAt from state and opts, which is deterministic, and applying
+// actions in order. Any progress recorded in state is discarded first.
+// The first game returned is the initial board, followed by the game
+// after each action.
+func Replay(id string, createdAt time.Time, state GameState, opts GameOptions, actions []Action) ([]*Game, error) {
+	if state.PermIndex < 0 || state.PermIndex+wordsPerGame > len(state.WordSet) {
+		return nil, fmt.Errorf("permutation index %d is out of range for %d words", state.PermIndex, len(state.WordSet))
+	}
+	if err := state.validMix(); err != nil {
+		return nil, err
+	}
+	if len(state.Tags) > 0 && len(state.Tags) != len(state.WordSet) {
+		return nil, fmt.Errorf("%d words have tags, expected %d", len(state.Tags), len(state.WordSet))
+	}
+	if err := state.checkFilters(opts); err != nil {
+		return nil, err
+	}
+	state.Revealed = make([]bool, wordsPerGame)
+	state.Round = 0
+
+	// Replay each action at the time it was originally made, so that
+	// replays are reproducible and record the original times.
+	clock := NewFakeClock(createdAt)
+	g := newGame(id, state, opts, clock)
+	games := []*Game{g.clone()}
+	for i, a := range actions {
+		if g.WinningTeam != nil {
+			return nil, fmt.Errorf("action %d: game is already over", i)
+		}
+		clock.Advance(a.At.Sub(clock.Now()))
+		switch a.Type {
+		case ActionGuess:
+			if err := g.Guess(a.Index); err != nil {
+				return nil, fmt.Errorf("action %d: %w", i, err)
+			}
+		case ActionEndTurn:
+			if a.Round != g.Round {
+				return nil, fmt.Errorf("action %d: ends round %d during round %d", i, a.Round, g.Round)
+			}
+			g.NextTurn(a.Round)
+		case ActionClue:
+			if a.Round != g.Round {
+				return nil, fmt.Errorf("action %d: gives a clue for round %d during round %d", i, a.Round, g.Round)
+			}
+			if err := g.GiveClue(a.Word, a.Number); err != nil {
+				return nil, fmt.Errorf("action %d: %w", i, err)
+			}
+		default:
+			return nil, fmt.Errorf("action %d: unknown action type %q", i, a.Type)
+		}
+		games = append(games, g.clone())
+	}
+	return games, nil
+}
+
+// clone returns a copy of g that doesn't share any mutable state.
+func (g *Game) clone() *Game {
+	c := *g
+	c.Revealed = append([]bool(nil), g.Revealed...)
+	c.Actions = append([]Action(nil), g.Actions...)
+	if g.WinningTeam != nil {
+		winner := *g.WinningTeam
+		c.WinningTeam = &winner
+	}
+	return &c
+}
+
+// ReplayStep is the state of a board after a single action.
+type ReplayStep struct {
+	Action      *Action `json:"action,omitempty"`
+	Round       int     `json:"round"`
+	CurrentTeam Team    `json:"current_team"`
+	Revealed    []bool  `json:"revealed"`
+	WinningTeam *Team   `json:"winning_team,omitempty"`
+}
+
+type replayResponse struct {
+	Words        []string     `json:"words"`
+	Layout       []Team       `json:"layout"`
+	StartingTeam Team         `json:"starting_team"`
+	Steps        []ReplayStep `json:"steps"`
+}
+
+func newReplayResponse(games []*Game, actions []Action) replayResponse {
+	resp := replayResponse{
+		Words:        games[0].Words,
+		Layout:       games[0].Layout,
+		StartingTeam: games[0].StartingTeam,
+	}
+	for i, g := range games {
+		step := ReplayStep{
+			Round:       g.Round,
+			CurrentTeam: g.CurrentTeam(),
+			Revealed:    g.Revealed,
+			WinningTeam: g.WinningTeam,
+		}
+		if i > 0 {
+			step.Action = &actions[i-1]
+		}
+		resp.Steps = append(resp.Steps, step)
+	}
+	return resp
+}
+
+// Limits on games posted to /replay.
+const (
+	maxReplayBytes   = 1 << 20
+	maxReplayActions = 1000
+)
+
+// POST /replay
+//
+// Replays an arbitrary game from its state, options and actions; the
+// request body has the same shape as an archived game.
+func (s *Server) handleReplay(rw http.ResponseWriter, req *http.Request) {
+	var g Game
+	if err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxReplayBytes)).Decode(&g); err != nil {
+		http.Error(rw, "Error decoding", 400)
+		return
+	}
+	if len(g.Actions) > maxReplayActions {
+		http.Error(rw, fmt.Sprintf("replays may have at most %d actions", maxReplayActions), 400)
+		return
+	}
+	s.writeReplay(rw, &g)
+}
+
+// GET /history/replay?room_id=<room id>&archive_id=<archive id>
+func (s *Server) handleHistoryReplay(rw http.ResponseWriter, req *http.Request) {
+	g, ok := s.archivedGame(rw, req)
+	if !ok {
+		return
+	}
+	s.writeReplay(rw, g)
+}
+
+func (s *Server) writeReplay(rw http.ResponseWriter, g *Game) {
+	games, err := Replay(g.ID, g.CreatedAt, g.GameState, g.GameOptions, g.Actions)
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	writeJSON(rw, newReplayResponse(games, g.Actions))
+}
@@ -0,0 +1,99 @@
+package codenames
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	state := randomState(testWords)
+	g := newGame("replay", state, GameOptions{}, SystemClock)
+
+	// Play a game: a clue, a couple of guesses, an ended turn, then the
+	// other team wins.
+	if err := g.GiveClue("zyzzyva", 2); err != nil {
+		t.Fatal(err)
+	}
+	var guessed int
+	for i, team := range g.Layout {
+		if team == g.StartingTeam && guessed < 2 {
+			if err := g.Guess(i); err != nil {
+				t.Fatal(err)
+			}
+			guessed++
+		}
+	}
+	g.NextTurn(g.Round)
+	playToWin(t, g, g.StartingTeam.Other())
+
+	games, err := Replay(g.ID, g.CreatedAt, g.GameState, g.GameOptions, g.Actions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(games) != len(g.Actions)+1 {
+		t.Fatalf("expected %d states, got %d", len(g.Actions)+1, len(games))
+	}
+	initial := games[0]
+	if !reflect.DeepEqual(initial.Words, g.Words) || !reflect.DeepEqual(initial.Layout, g.Layout) {
+		t.Fatal("replayed board doesn't match the original")
+	}
+	for i, r := range initial.Revealed {
+		if r {
+			t.Fatalf("word %d revealed on the initial board", i)
+		}
+	}
+	final := games[len(games)-1]
+	if !reflect.DeepEqual(final.Revealed, g.Revealed) || final.Round != g.Round {
+		t.Error("replayed final state doesn't match the original")
+	}
+	if final.WinningTeam == nil || *final.WinningTeam != *g.WinningTeam {
+		t.Error("replayed game has a different winner")
+	}
+	// Intermediate states must not share memory.
+	if games[1].Revealed[g.Actions[len(g.Actions)-1].Index] {
+		t.Error("intermediate state was modified by a later action")
+	}
+
+	// Games are replayed from their creation time.
+	if !initial.CreatedAt.Equal(g.CreatedAt) || !final.UpdatedAt.Equal(g.UpdatedAt) {
+		t.Errorf("replay ran from %s to %s, expected %s to %s", initial.CreatedAt, final.UpdatedAt, g.CreatedAt, g.UpdatedAt)
+	}
+
+	// Invalid action lists are rejected.
+	bad := append(append([]Action(nil), g.Actions[:1]...), g.Actions[0])
+	if _, err := Replay(g.ID, g.CreatedAt, g.GameState, g.GameOptions, bad); err == nil {
+		t.Error("expected an error replaying the same guess twice")
+	}
+	if _, err := Replay(g.ID, g.CreatedAt, g.GameState, g.GameOptions, []Action{{Type: ActionGuess, Index: 25}}); err == nil {
+		t.Error("expected an error replaying an out of range guess")
+	}
+}
+
+func TestReplayLimits(t *testing.T) {
+	s := newTestServer(nil)
+	g := newGame("replay", randomState(testWords), GameOptions{}, SystemClock)
+	g.NextTurn(0)
+
+	var resp replayResponse
+	if code := do(t, s.handleReplay, "POST", g, &resp); code != 200 || len(resp.Steps) != 2 {
+		t.Fatalf("status %d, %d steps", code, len(resp.Steps))
+	}
+
+	long := *g
+	for len(long.Actions) <= maxReplayActions {
+		long.Actions = append(long.Actions, Action{Type: ActionEndTurn, Round: len(long.Actions)})
+	}
+	if code := do(t, s.handleReplay, "POST", &long, nil); code != 400 {
+		t.Errorf("replaying %d actions: status %d, expected 400", len(long.Actions), code)
+	}
+
+	large := *g
+	large.WordSet = append([]string(nil), g.WordSet...)
+	for i := 0; i < maxReplayBytes/10; i++ {
+		large.WordSet = append(large.WordSet, fmt.Sprintf("WORD%d", i))
+	}
+	if code := do(t, s.handleReplay, "POST", &large, nil); code != 400 {
+		t.Errorf("replaying a %d word game: status %d, expected 400", len(large.WordSet), code)
+	}
+}
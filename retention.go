@@ -0,0 +1,49 @@
+package codenames
+
+import "time"
+
+// RetentionPolicy determines how long games are kept after their last
+// activity. The same policy is applied to games held in memory by the
+// Server and to games persisted in the Store, so a game that's still
+// playable is never lost on restart.
+type RetentionPolicy struct {
+	// Finished is how long a game with a winner is kept after it
+	// was last updated.
+	Finished time.Duration
+	// Idle is how long any game is kept after it was last updated.
+	Idle time.Duration
+	// Room is how long a room, and its current game, are kept after
+	// the room was last updated.
+	Room time.Duration
+	// Archive is how long a completed game is kept in the archive
+	// after it ended.
+	Archive time.Duration
+}
+
+// DefaultRetentionPolicy is the policy of the codenames command when no
+// retention flags are given.
+var DefaultRetentionPolicy = RetentionPolicy{
+	Finished: 3 * time.Hour,
+	Idle:     24 * time.Hour,
+	Room:     30 * 24 * time.Hour,
+	Archive:  90 * 24 * time.Hour,
+}
+
+// Expired returns true if g should be discarded at time now. Pinned
+// games never expire. A zero duration disables the corresponding
+// expiry.
+func (p RetentionPolicy) Expired(g *Game, now time.Time) bool {
+	if g.Pinned {
+		return false
+	}
+	if g.WinningTeam != nil && p.Finished > 0 && g.UpdatedAt.Add(p.Finished).Before(now) {
+		return true
+	}
+	return p.Idle > 0 && g.UpdatedAt.Add(p.Idle).Before(now)
+}
+
+// RoomExpired returns true if r should be discarded at time now. A zero
+// Room duration keeps rooms forever.
+func (p RetentionPolicy) RoomExpired(r *Room, now time.Time) bool {
+	return p.Room > 0 && r.UpdatedAt.Add(p.Room).Before(now)
+}
@@ -0,0 +1,107 @@
+package codenames
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyExpired(t *testing.T) {
+	now := time.Date(2020, 11, 14, 18, 0, 0, 0, time.UTC)
+	red := Red
+	policy := RetentionPolicy{Finished: 3 * time.Hour, Idle: 24 * time.Hour}
+
+	testCases := []struct {
+		name      string
+		updatedAt time.Time
+		finished  bool
+		pinned    bool
+		want      bool
+	}{
+		{name: "active", updatedAt: now.Add(-time.Hour)},
+		{name: "old but recently updated", updatedAt: now.Add(-23 * time.Hour)},
+		{name: "idle", updatedAt: now.Add(-25 * time.Hour), want: true},
+		{name: "recently finished", updatedAt: now.Add(-time.Hour), finished: true},
+		{name: "finished long ago", updatedAt: now.Add(-4 * time.Hour), finished: true, want: true},
+		{name: "pinned and idle", updatedAt: now.Add(-1000 * time.Hour), finished: true, pinned: true},
+	}
+	for _, tc := range testCases {
+		g := &Game{
+			CreatedAt: now.Add(-1000 * time.Hour),
+			UpdatedAt: tc.updatedAt,
+			Pinned:    tc.pinned,
+		}
+		if tc.finished {
+			g.WinningTeam = &red
+		}
+		if got := policy.Expired(g, now); got != tc.want {
+			t.Errorf("%s: Expired = %t, expected %t", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	ps := openTestStore(t, "test-expiry-*")
+	defer ps.DB.Close()
+
+	now := time.Now()
+	games := randomGames(3)
+	ids := gameIDs[:3]
+	// An old game that's still being played must survive.
+	games[ids[0]].CreatedAt = now.Add(-30 * time.Hour)
+	games[ids[0]].UpdatedAt = now.Add(-time.Minute)
+	// An idle game must be deleted, even though it's newer.
+	games[ids[1]].CreatedAt = now.Add(-26 * time.Hour)
+	games[ids[1]].UpdatedAt = now.Add(-25 * time.Hour)
+	// A pinned idle game must survive.
+	games[ids[2]].CreatedAt = now.Add(-100 * time.Hour)
+	games[ids[2]].UpdatedAt = now.Add(-100 * time.Hour)
+	games[ids[2]].Pinned = true
+	for _, g := range games {
+		if err := ps.Save(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := ps.DeleteExpired(DefaultRetentionPolicy, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("deleted %d games, expected 1", n)
+	}
+	restored, err := ps.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		if _, ok := restored[id]; ok != (i != 1) {
+			t.Errorf("game %d (%q) restored = %t", i, id, ok)
+		}
+	}
+}
+
+func TestZeroRetentionPolicy(t *testing.T) {
+	ps := openTestStore(t, "test-zero-retention-*")
+	defer ps.DB.Close()
+
+	// A zero policy keeps everything, on disk and in memory alike.
+	now := time.Now()
+	red := Red
+	g := newGame("ancient", randomState(testWords), GameOptions{}, SystemClock)
+	g.UpdatedAt = now.Add(-1000 * time.Hour)
+	g.WinningTeam = &red
+	if err := ps.Save(g); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := ps.DeleteExpired(RetentionPolicy{}, now); err != nil || n != 0 {
+		t.Errorf("deleted %d games, %v", n, err)
+	}
+
+	s := newTestServer(ps)
+	s.Retention = RetentionPolicy{}
+	s.games[g.ID] = &GameHandle{store: ps, g: g}
+	s.cleanupOldGames()
+	if _, ok := s.games[g.ID]; !ok {
+		t.Error("game was expired from memory under a zero policy")
+	}
+}
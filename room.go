@@ -0,0 +1,516 @@
+package codenames
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRoomHistory is the number of past games remembered by a room.
+const maxRoomHistory = 100
+
+// maxRecentWords is the number of recently played words remembered by a
+// room.
+const maxRecentWords = 500
+
+// Room is a standing group of players who play many consecutive games
+// under the same ID. Rooms are persisted separately from games, so a
+// room's settings and history outlive any individual game.
+type Room struct {
+	ID             string        `json:"id"`
+	Owner          string        `json:"owner,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+	DefaultOptions GameOptions   `json:"default_options"`
+	WordSet        []string      `json:"word_set,omitempty"`
+	Tags           []WordTags    `json:"tags,omitempty"`
+	Members        []string      `json:"members,omitempty"`
+	History        []GameSummary `json:"history,omitempty"`
+	Scoreboard     Scoreboard    `json:"scoreboard"`
+	// RecentWords holds the words of the room's most recent games, from
+	// least to most recently played.
+	RecentWords []string `json:"recent_words,omitempty"`
+	// ClaimHash is the hex SHA-256 hash of the token needed to change
+	// the room's settings, if the room has been claimed.
+	ClaimHash string `json:"claim_hash,omitempty"`
+}
+
+// GameSummary records the outcome of one of a room's past games.
+type GameSummary struct {
+	ArchiveID   string    `json:"archive_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	EndedAt     time.Time `json:"ended_at"`
+	WinningTeam *Team     `json:"winning_team,omitempty"`
+	Rounds      int       `json:"rounds"`
+}
+
+func summarize(g *Game) GameSummary {
+	summary := GameSummary{
+		CreatedAt:   g.CreatedAt,
+		EndedAt:     g.UpdatedAt,
+		WinningTeam: g.WinningTeam,
+		Rounds:      g.Round + 1,
+	}
+	if g.WinningTeam != nil {
+		// Completed games are archived when they end.
+		summary.ArchiveID = archiveID(g)
+	}
+	return summary
+}
+
+// recordGame appends a summary of g to the room's history, and
+// remembers its words as recently played.
+func (r *Room) recordGame(g *Game) {
+	r.History = append(r.History, summarize(g))
+	if len(r.History) > maxRoomHistory {
+		r.History = append([]GameSummary(nil), r.History[len(r.History)-maxRoomHistory:]...)
+	}
+	r.recordWords(g.Words)
+}
+
+// recordWords moves words to the end of the room's recently played
+// words, forgetting the least recently played beyond maxRecentWords.
+func (r *Room) recordWords(words []string) {
+	played := map[string]bool{}
+	for _, w := range words {
+		played[w] = true
+	}
+	recent := make([]string, 0, len(r.RecentWords)+len(words))
+	for _, w := range r.RecentWords {
+		if !played[w] {
+			recent = append(recent, w)
+		}
+	}
+	recent = append(recent, words...)
+	if len(recent) > maxRecentWords {
+		recent = recent[len(recent)-maxRecentWords:]
+	}
+	r.RecentWords = recent
+}
+
+// addMember adds name to the room's members, if it's not already
+// present.
+func (r *Room) addMember(name string) bool {
+	for _, m := range r.Members {
+		if m == name {
+			return false
+		}
+	}
+	r.Members = append(r.Members, name)
+	sort.Strings(r.Members)
+	return true
+}
+
+type RoomHandle struct {
+	store Store
+	clock Clock
+
+	mu        sync.Mutex
+	marshaled []byte
+	r         *Room
+}
+
+func newRoomHandle(r *Room, s Store, clock Clock) *RoomHandle {
+	rh := &RoomHandle{store: s, clock: clock, r: r}
+	err := s.SaveRoom(r)
+	if err != nil {
+		log.Printf("Unable to write room %q to disk: %s\n", r.ID, err)
+	}
+	return rh
+}
+
+func (rh *RoomHandle) update(fn func(*Room) bool) {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	if !fn(rh.r) {
+		return
+	}
+	rh.r.UpdatedAt = rh.clock.Now()
+	rh.marshaled = nil
+
+	err := rh.store.SaveRoom(rh.r)
+	if err != nil {
+		log.Printf("Unable to write updated room %q to disk: %s\n", rh.r.ID, err)
+	}
+}
+
+// defaults returns the word set, its tags and the game options that new
+// games in the room should use when a request doesn't specify its own.
+func (rh *RoomHandle) defaults() ([]string, []WordTags, GameOptions) {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	return rh.r.WordSet, rh.r.Tags, rh.r.DefaultOptions
+}
+
+// claimedBy returns true if the room isn't claimed, or token is its
+// claim token.
+func (r *Room) claimedBy(token string) bool {
+	if r.ClaimHash == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(hashClaimToken(token)), []byte(r.ClaimHash)) == 1
+}
+
+func hashClaimToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// recentWords returns the words the room played recently.
+func (rh *RoomHandle) recentWords() []string {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	return rh.r.RecentWords
+}
+
+// MarshalJSON implements the encoding/json.Marshaler interface.
+// It caches a marshalled value of the room object. The hash of the
+// room's claim token is left out, so that it can't be brute-forced by
+// anyone who sees the room.
+func (rh *RoomHandle) MarshalJSON() ([]byte, error) {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+
+	var err error
+	if rh.marshaled == nil {
+		rh.marshaled, err = json.Marshal(struct {
+			*Room
+			ClaimHash string `json:"claim_hash,omitempty"`
+			Claimed   bool   `json:"claimed"`
+		}{Room: rh.r, Claimed: rh.r.ClaimHash != ""})
+	}
+	return rh.marshaled, err
+}
+
+// GET /room?id=<room id>
+func (s *Server) handleGetRoom(rw http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	rh, ok := s.rooms[req.URL.Query().Get("id")]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+	writeJSON(rw, rh)
+}
+
+// POST /room
+//
+// Creates the room if it doesn't exist, and updates any of the provided
+// settings. A room's owner can only be set when it's created.
+func (s *Server) handleRoom(rw http.ResponseWriter, req *http.Request) {
+	if req.Method == "GET" {
+		s.handleGetRoom(rw, req)
+		return
+	}
+
+	var request struct {
+		RoomID         string       `json:"room_id"`
+		Owner          string       `json:"owner"`
+		ClaimToken     string       `json:"claim_token"`
+		DefaultOptions *GameOptions `json:"default_options"`
+		WordSet        []string     `json:"word_set"`
+		WordSets       []string     `json:"word_sets"`
+		Language       string       `json:"language"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(rw, "Error decoding", 400)
+		return
+	}
+	if err := s.checkGameID(request.RoomID); err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	named, err := s.wordSets.Named(request.WordSets)
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	if request.Language == "" {
+		request.Language = s.wordSets.Language(request.WordSets)
+	}
+	words, err := normalizeWordSet(append(named[:len(named):len(named)], request.WordSet...), request.Language)
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	tags := alignTags(words, s.wordSets.Tags(request.WordSets))
+	if request.DefaultOptions != nil {
+		request.DefaultOptions.Category = strings.ToLower(strings.TrimSpace(request.DefaultOptions.Category))
+	}
+
+	rh := s.getOrCreateRoom(request.RoomID, strings.TrimSpace(request.Owner))
+	var forbidden bool
+	var invalid error
+	rh.update(func(r *Room) bool {
+		if !r.claimedBy(request.ClaimToken) {
+			forbidden = true
+			return false
+		}
+		var updated bool
+		opts, roomWords, roomTags := r.DefaultOptions, r.WordSet, r.Tags
+		if request.DefaultOptions != nil {
+			opts = *request.DefaultOptions
+			updated = true
+		}
+		if len(words) > 0 {
+			roomWords, roomTags = words, tags
+			updated = true
+		}
+		// The room's filters must leave enough of its words for a
+		// board, so that its games are guaranteed to respect them.
+		if opts.filtered() {
+			if len(roomWords) == 0 {
+				invalid = errors.New("filters need a tagged word set")
+				return false
+			}
+			state := GameState{WordSet: roomWords, Tags: roomTags}
+			if invalid = state.checkFilters(opts); invalid != nil {
+				return false
+			}
+		}
+		r.DefaultOptions, r.WordSet, r.Tags = opts, roomWords, roomTags
+		return updated
+	})
+	if forbidden {
+		http.Error(rw, "the room has been claimed; its claim token is required", http.StatusForbidden)
+		return
+	}
+	if invalid != nil {
+		http.Error(rw, invalid.Error(), 400)
+		return
+	}
+	writeJSON(rw, rh)
+}
+
+// POST /claim-room
+//
+// Creates a room claimed by the caller, so that only the holder of the
+// returned claim token can change its settings. Only IDs not yet used by
+// a game or room can be claimed, so that nobody can take over a room
+// someone else started.
+func (s *Server) handleClaimRoom(rw http.ResponseWriter, req *http.Request) {
+	var request struct {
+		RoomID string `json:"room_id"`
+		Owner  string `json:"owner"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(rw, "Error decoding", 400)
+		return
+	}
+	if err := s.validateGameID(request.RoomID); err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(rw, "error generating claim token", http.StatusInternalServerError)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	rh, created := s.createClaimedRoom(request.RoomID, strings.TrimSpace(request.Owner), hashClaimToken(token))
+	if !created {
+		http.Error(rw, "a game or room with this ID already exists", http.StatusConflict)
+		return
+	}
+	writeJSON(rw, struct {
+		Room       *RoomHandle `json:"room"`
+		ClaimToken string      `json:"claim_token"`
+	}{rh, token})
+}
+
+// POST /join-room
+func (s *Server) handleJoinRoom(rw http.ResponseWriter, req *http.Request) {
+	var request struct {
+		RoomID string `json:"room_id"`
+		Name   string `json:"name"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(rw, "Error decoding", 400)
+		return
+	}
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		http.Error(rw, "name is required", 400)
+		return
+	}
+
+	s.mu.Lock()
+	rh, ok := s.rooms[request.RoomID]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+	rh.update(func(r *Room) bool {
+		return r.addMember(name)
+	})
+	writeJSON(rw, rh)
+}
+
+// Roles that can be encoded in a join link.
+const (
+	RoleSpymaster = "spymaster"
+	RoleOperative = "operative"
+)
+
+// Limits on the size of QR codes, in pixels or user units per module.
+const (
+	defaultQRScale = 8
+	maxQRScale     = 32
+)
+
+// maxJoinTokenLength is the longest join token that can be encoded in a
+// join link.
+const maxJoinTokenLength = 128
+
+// GET /room-qr?room_id=<id>[&role=spymaster|operative][&token=<token>][&format=png|svg][&scale=<n>]
+//
+// Renders a QR code of a link to join a room, so that players can get
+// from a shared screen onto their phones without typing the room ID. The
+// link can open the game as a spymaster or an operative, and carry a
+// join token for the room's players.
+func (s *Server) handleRoomQR(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	roomID := query.Get("room_id")
+	if err := s.checkGameID(roomID); err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	role := query.Get("role")
+	if role != "" && role != RoleSpymaster && role != RoleOperative {
+		http.Error(rw, fmt.Sprintf("role must be %q or %q", RoleSpymaster, RoleOperative), 400)
+		return
+	}
+	token := query.Get("token")
+	if err := validateJoinToken(token); err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	scale := defaultQRScale
+	if v := query.Get("scale"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxQRScale {
+			http.Error(rw, fmt.Sprintf("scale must be between 1 and %d", maxQRScale), 400)
+			return
+		}
+		scale = n
+	}
+
+	code, err := encodeQR([]byte(s.joinURL(req, roomID, role, token)))
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	var buf bytes.Buffer
+	switch format := query.Get("format"); format {
+	case "png", "":
+		rw.Header().Set("Content-Type", "image/png")
+		err = code.WritePNG(&buf, scale)
+	case "svg":
+		rw.Header().Set("Content-Type", "image/svg+xml")
+		err = code.WriteSVG(&buf, scale)
+	default:
+		http.Error(rw, fmt.Sprintf("unknown format %q", format), 400)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "error rendering QR code", http.StatusInternalServerError)
+		return
+	}
+	rw.Write(buf.Bytes())
+}
+
+// validateJoinToken returns an error if token can't be carried in a join
+// link. Tokens are opaque to the server, but must be URL-safe base64.
+func validateJoinToken(token string) error {
+	if len(token) > maxJoinTokenLength {
+		return fmt.Errorf("join tokens can't be longer than %d characters", maxJoinTokenLength)
+	}
+	for _, r := range token {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return errors.New("join tokens must be URL-safe base64")
+		}
+	}
+	return nil
+}
+
+// joinURL returns the absolute URL of a room as seen by the client of
+// req, with an optional role and join token.
+func (s *Server) joinURL(req *http.Request, roomID, role, token string) string {
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: req.Host, Path: "/" + roomID}
+	params := url.Values{}
+	if role != "" {
+		params.Set("role", role)
+	}
+	if token != "" {
+		params.Set("token", token)
+	}
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+func (s *Server) getOrCreateRoom(id, owner string) *RoomHandle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rh, ok := s.rooms[id]
+	if ok {
+		return rh
+	}
+	return s.addRoom(id, owner, "")
+}
+
+// createClaimedRoom creates a room claimed by the holder of the token
+// hashed to claimHash. It returns false if a game or room already has
+// the ID.
+func (s *Server) createClaimedRoom(id, owner, claimHash string) (*RoomHandle, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, game := s.games[id]
+	_, room := s.rooms[id]
+	if game || room {
+		return nil, false
+	}
+	return s.addRoom(id, owner, claimHash), true
+}
+
+// addRoom creates a room, moving any game with its ID into it. s.mu must
+// be held.
+func (s *Server) addRoom(id, owner, claimHash string) *RoomHandle {
+	now := s.Clock.Now()
+	rh := newRoomHandle(&Room{
+		ID:        id,
+		Owner:     owner,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ClaimHash: claimHash,
+	}, s.Store, s.Clock)
+	s.rooms[id] = rh
+	s.releaseGameID(id)
+	if gh, ok := s.games[id]; ok {
+		gh.setRoom(rh)
+	}
+	return rh
+}
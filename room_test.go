@@ -0,0 +1,278 @@
+package codenames
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoomDefaultsAndHistory(t *testing.T) {
+	ps := openTestStore(t, "test-rooms-*")
+	defer ps.DB.Close()
+	s := newTestServer(ps)
+
+	var room Room
+	code := do(t, s.handleRoom, "POST", map[string]interface{}{
+		"room_id":         "friday-night",
+		"owner":           "sam",
+		"default_options": GameOptions{TimerDurationMS: 90000, EnforceTimer: true},
+		"word_set":        testWords[:30],
+	}, &room)
+	if code != 200 {
+		t.Fatalf("creating room: status %d", code)
+	}
+	do(t, s.handleJoinRoom, "POST", map[string]string{"room_id": "friday-night", "name": "alex"}, &room)
+	do(t, s.handleJoinRoom, "POST", map[string]string{"room_id": "friday-night", "name": "alex"}, &room)
+	if len(room.Members) != 1 || room.Owner != "sam" {
+		t.Errorf("unexpected room: %+v", room)
+	}
+
+	// New games in the room should use the room's defaults.
+	var g Game
+	do(t, s.handleNextGame, "POST", map[string]interface{}{"game_id": "friday-night"}, &g)
+	if g.TimerDurationMS != 90000 || !g.EnforceTimer || len(g.WordSet) != 30 {
+		t.Errorf("game didn't use room defaults: timer %d, enforce %t, %d words",
+			g.TimerDurationMS, g.EnforceTimer, len(g.WordSet))
+	}
+
+	// Replacing the game should record it in the room's history.
+	for i := 0; i < 3; i++ {
+		do(t, s.handleNextGame, "POST", map[string]interface{}{
+			"game_id":    "friday-night",
+			"create_new": true,
+		}, &g)
+	}
+	if len(s.rooms["friday-night"].r.History) != 3 {
+		t.Errorf("expected 3 games in the room's history, got %d", len(s.rooms["friday-night"].r.History))
+	}
+
+	// The room should survive a restart.
+	rooms, err := ps.RestoreRooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := rooms["friday-night"]
+	if !ok {
+		t.Fatal("room wasn't persisted")
+	}
+	if len(r.History) != 3 || len(r.Members) != 1 || r.DefaultOptions.TimerDurationMS != 90000 {
+		t.Errorf("room wasn't restored correctly: %+v", r)
+	}
+}
+
+func TestRoomKeepsGameAlive(t *testing.T) {
+	ps := openTestStore(t, "test-rooms-expiry-*")
+	defer ps.DB.Close()
+
+	now := time.Now()
+	games := randomGames(2)
+	for _, g := range games {
+		g.CreatedAt = now.Add(-48 * time.Hour)
+		g.UpdatedAt = now.Add(-48 * time.Hour)
+		if err := ps.Save(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	roomID := gameIDs[0]
+	if err := ps.SaveRoom(&Room{ID: roomID, CreatedAt: now.Add(-72 * time.Hour), UpdatedAt: now.Add(-6 * 24 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.SaveRoom(&Room{ID: "abandoned", UpdatedAt: now.Add(-60 * 24 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ps.DeleteExpired(DefaultRetentionPolicy, now); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ps.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := restored[roomID]; !ok {
+		t.Error("the current game of an active room expired")
+	}
+	if _, ok := restored[gameIDs[1]]; ok {
+		t.Error("an idle game outside of a room didn't expire")
+	}
+	rooms, err := ps.RestoreRooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rooms["abandoned"]; ok {
+		t.Error("an abandoned room didn't expire")
+	}
+}
+
+func TestRoomDefersRecentWords(t *testing.T) {
+	ps := openTestStore(t, "test-rooms-recent-*")
+	defer ps.DB.Close()
+	s := newTestServer(ps)
+
+	// Sixty words make a cycle of two games, leaving ten words unplayed.
+	var room Room
+	code := do(t, s.handleRoom, "POST", map[string]interface{}{
+		"room_id":  "regulars",
+		"word_set": testWords[:60],
+	}, &room)
+	if code != 200 {
+		t.Fatalf("creating room: status %d", code)
+	}
+
+	var g Game
+	do(t, s.handleNextGame, "POST", map[string]interface{}{"game_id": "regulars"}, &g)
+	for i := 0; i < 10; i++ {
+		played := map[string]bool{}
+		for _, w := range s.rooms["regulars"].r.RecentWords {
+			played[w] = true
+		}
+		for _, w := range g.Words {
+			played[w] = true
+		}
+		do(t, s.handleNextGame, "POST", map[string]interface{}{
+			"game_id":    "regulars",
+			"create_new": true,
+		}, &g)
+		if g.PermIndex != 0 {
+			continue
+		}
+		// A new cycle must start with every word the room hasn't
+		// played in its last two games.
+		onBoard := map[string]bool{}
+		for _, w := range g.Words {
+			onBoard[w] = true
+		}
+		for _, w := range room.WordSet {
+			if !played[w] && !onBoard[w] {
+				t.Errorf("game %d: unplayed word %q wasn't on the board", i, w)
+			}
+		}
+		if again := newGame("again", g.GameState, g.GameOptions, SystemClock); !equalWords(again.Words, g.Words) {
+			t.Errorf("game %d isn't reproducible from its state", i)
+		}
+	}
+
+	// Recent words survive a restart, and are avoided when the room's
+	// game is recreated.
+	rooms, err := ps.RestoreRooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent := rooms["regulars"].RecentWords
+	if len(recent) != 60 {
+		t.Fatalf("room remembered %d recent words, expected 60", len(recent))
+	}
+	s = newTestServer(ps)
+	s.rooms["regulars"] = newRoomHandle(rooms["regulars"], ps, SystemClock)
+	var fresh Game
+	do(t, s.handleGameState, "POST", map[string]interface{}{"game_id": "regulars"}, &fresh)
+	if len(fresh.Deferred) != 60 {
+		t.Errorf("recreated game deferred %d words, expected 60", len(fresh.Deferred))
+	}
+	// Every word was played recently, so the board has the words played
+	// longest ago.
+	if !equalWords(sortedCopy(fresh.Words), sortedCopy(recent[:wordsPerGame])) {
+		t.Errorf("recreated game has %q, expected the least recently played words %q", fresh.Words, recent[:wordsPerGame])
+	}
+}
+
+func TestRecordWords(t *testing.T) {
+	var r Room
+	r.recordWords([]string{"A", "B", "C"})
+	r.recordWords([]string{"B", "D"})
+	if !equalWords(r.RecentWords, []string{"A", "C", "B", "D"}) {
+		t.Errorf("recent words %q", r.RecentWords)
+	}
+	many := make([]string, maxRecentWords)
+	for i := range many {
+		many[i] = fmt.Sprint(i)
+	}
+	r.recordWords(many)
+	if len(r.RecentWords) != maxRecentWords || r.RecentWords[0] != "0" {
+		t.Errorf("recent words weren't trimmed: %d words from %q", len(r.RecentWords), r.RecentWords[0])
+	}
+}
+
+func sortedCopy(words []string) []string {
+	sorted := append([]string(nil), words...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func TestClaimRoom(t *testing.T) {
+	ps := openTestStore(t, "test-rooms-claim-*")
+	defer ps.DB.Close()
+	s := newTestServer(ps)
+
+	var claim struct {
+		Room       Room   `json:"room"`
+		ClaimToken string `json:"claim_token"`
+	}
+	code := do(t, s.handleClaimRoom, "POST", map[string]string{"room_id": "friday-night", "owner": "sam"}, &claim)
+	if code != 200 || claim.ClaimToken == "" || claim.Room.Owner != "sam" {
+		t.Fatalf("claiming room: status %d, %+v", code, claim)
+	}
+	if code := do(t, s.handleClaimRoom, "POST", map[string]string{"room_id": "friday-night"}, nil); code != 409 {
+		t.Errorf("claiming a claimed room: status %d, expected 409", code)
+	}
+	if code := do(t, s.handleClaimRoom, "POST", map[string]string{"room_id": "Friday Night"}, nil); code != 400 {
+		t.Errorf("claiming an unnormalized room ID: status %d, expected 400", code)
+	}
+
+	// Rooms and games someone else started can't be claimed.
+	do(t, s.handleRoom, "POST", map[string]string{"room_id": "book-club", "owner": "robin"}, nil)
+	if code := do(t, s.handleClaimRoom, "POST", map[string]string{"room_id": "book-club", "owner": "sam"}, nil); code != 409 {
+		t.Errorf("claiming an existing room: status %d, expected 409", code)
+	}
+	if r := s.rooms["book-club"].r; r.Owner != "robin" || r.ClaimHash != "" {
+		t.Errorf("existing room was taken over: %+v", r)
+	}
+	s.getGame("pickup")
+	if code := do(t, s.handleClaimRoom, "POST", map[string]string{"room_id": "pickup"}, nil); code != 409 {
+		t.Errorf("claiming an existing game: status %d, expected 409", code)
+	}
+
+	// Only the claim token's holder can change the room's settings.
+	settings := map[string]interface{}{
+		"room_id":         "friday-night",
+		"default_options": GameOptions{TimerDurationMS: 60000},
+	}
+	if code := do(t, s.handleRoom, "POST", settings, nil); code != 403 {
+		t.Errorf("changing a claimed room without its token: status %d, expected 403", code)
+	}
+	settings["claim_token"] = "guess"
+	if code := do(t, s.handleRoom, "POST", settings, nil); code != 403 {
+		t.Errorf("changing a claimed room with the wrong token: status %d, expected 403", code)
+	}
+	settings["claim_token"] = claim.ClaimToken
+	var room Room
+	if code := do(t, s.handleRoom, "POST", settings, &room); code != 200 || room.DefaultOptions.TimerDurationMS != 60000 {
+		t.Errorf("changing a claimed room with its token: status %d, %+v", code, room.DefaultOptions)
+	}
+
+	// Anyone can still join and play.
+	if code := do(t, s.handleJoinRoom, "POST", map[string]string{"room_id": "friday-night", "name": "alex"}, nil); code != 200 {
+		t.Errorf("joining a claimed room: status %d", code)
+	}
+
+	// The hash of the claim token isn't shown to visitors.
+	rec := httptest.NewRecorder()
+	s.handleGetRoom(rec, httptest.NewRequest("GET", "/room?id=friday-night", nil))
+	if body := rec.Body.String(); strings.Contains(body, "claim_hash") || strings.Contains(body, hashClaimToken(claim.ClaimToken)) {
+		t.Errorf("room's JSON contains its claim hash: %s", body)
+	} else if !strings.Contains(body, `"claimed":true`) {
+		t.Errorf("room's JSON doesn't say it's claimed: %s", body)
+	}
+
+	// Claims survive a restart.
+	rooms, err := ps.RestoreRooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := rooms["friday-night"]
+	if restored.claimedBy("") || !restored.claimedBy(claim.ClaimToken) {
+		t.Error("room's claim wasn't restored")
+	}
+}
@@ -0,0 +1,55 @@
+package codenames
+
+// Scoreboard is a running tally of the results of the games played in a
+// room, kept until it's reset.
+type Scoreboard struct {
+	Games              int `json:"games"`
+	RedWins            int `json:"red_wins"`
+	BlueWins           int `json:"blue_wins"`
+	RedAssassinLosses  int `json:"red_assassin_losses"`
+	BlueAssassinLosses int `json:"blue_assassin_losses"`
+	// ClearedWins counts the games won by revealing all of a team's
+	// cards, rather than by the other team revealing the assassin. Only
+	// those games count towards the turns taken to win.
+	ClearedWins       int     `json:"cleared_wins"`
+	TotalTurnsToWin   int     `json:"total_turns_to_win"`
+	AverageTurnsToWin float64 `json:"average_turns_to_win"`
+	StreakTeam        Team    `json:"streak_team"`
+	Streak            int     `json:"streak"`
+	LongestStreakTeam Team    `json:"longest_streak_team"`
+	LongestStreak     int     `json:"longest_streak"`
+}
+
+// record tallies a game won by winners after the given number of turns.
+// If assassin is true, the game ended with the losing team revealing
+// the assassin.
+func (sb *Scoreboard) record(winners Team, assassin bool, turns int) {
+	sb.Games++
+	switch winners {
+	case Red:
+		sb.RedWins++
+	case Blue:
+		sb.BlueWins++
+	}
+	if assassin {
+		switch winners.Other() {
+		case Red:
+			sb.RedAssassinLosses++
+		case Blue:
+			sb.BlueAssassinLosses++
+		}
+	} else {
+		sb.ClearedWins++
+		sb.TotalTurnsToWin += turns
+		sb.AverageTurnsToWin = float64(sb.TotalTurnsToWin) / float64(sb.ClearedWins)
+	}
+
+	if sb.StreakTeam == winners {
+		sb.Streak++
+	} else {
+		sb.StreakTeam, sb.Streak = winners, 1
+	}
+	if sb.Streak > sb.LongestStreak {
+		sb.LongestStreakTeam, sb.LongestStreak = sb.StreakTeam, sb.Streak
+	}
+}
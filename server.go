@@ -3,6 +3,7 @@ package codenames
 import (
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"html/template"
 	"io"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"net/http/pprof"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -28,12 +30,23 @@ func init() {
 type Server struct {
 	Server http.Server
 	Store  Store
-
-	tpl         *template.Template
-	gameIDWords []string
+	// Retention is applied to games and rooms in memory. It must be the
+	// policy the Store's games were expired with, so that memory and
+	// disk agree; its zero value keeps everything.
+	Retention RetentionPolicy
+	Clock     Clock
+	// GameIDFormat and GameIDEntropy configure the game IDs suggested
+	// to visitors. See IDGenerator.
+	GameIDFormat  IDFormat
+	GameIDEntropy int
+
+	tpl      *template.Template
+	gameIDs  *IDGenerator
+	wordSets WordSets
 
 	mu           sync.Mutex
 	games        map[string]*GameHandle
+	rooms        map[string]*RoomHandle
 	defaultWords []string
 	mux          *http.ServeMux
 
@@ -44,7 +57,15 @@ type Server struct {
 type Store interface {
 	Save(*Game) error
 	Delete(*Game) error
-	Checkpoint(io.Writer) error
+	Checkpoint(w io.Writer, have map[string]string) error
+	Quarantined() ([]QuarantinedRecord, error)
+	Changes(since uint64, limit int) ([]Change, <-chan struct{}, error)
+	SaveRoom(*Room) error
+	DeleteRoom(*Room) error
+	Archive(*Game) error
+	ArchivedGames(roomID string) ([]GameSummary, error)
+	ArchivedGame(roomID, archiveID string) (*Game, error)
+	SaveWordPack(*WordPack) error
 }
 
 type GameHandle struct {
@@ -55,6 +76,9 @@ type GameHandle struct {
 	replaced  chan struct{} // closed when the game has been replaced
 	marshaled []byte
 	g         *Game
+	// room is the room the game is played in, if any. Its scoreboard
+	// tallies the game's result and is included with the game.
+	room *RoomHandle
 }
 
 func newHandle(g *Game, s Store) *GameHandle {
@@ -71,15 +95,48 @@ func newHandle(g *Game, s Store) *GameHandle {
 	return gh
 }
 
+// newGameHandle sets g's clock to the server's and interns its word set,
+// so that games played with the same words share memory and the game
+// can be shared by board code, and returns a new handle for g in the
+// room with g's ID, if there is one. s.mu must be held.
+func (s *Server) newGameHandle(g *Game) *GameHandle {
+	g.clock = s.Clock
+	id, words, err := s.wordSets.Canonicalize(g.WordSet)
+	// Board codes don't record how packs are mixed, which words are
+	// deferred or the words' tags, so those boards can't be shared by
+	// code.
+	if err == nil && equalWords(words, g.WordSet) && len(g.Mix) == 0 && len(g.Deferred) == 0 && len(g.Tags) == 0 {
+		g.WordSet = s.wordSets.remember(id, words)
+		g.BoardCode = newBoardCode(g, id).String()
+	}
+	gh := newHandle(g, s.Store)
+	gh.room = s.rooms[g.ID]
+	return gh
+}
+
+func equalWords(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (gh *GameHandle) update(fn func(*Game) bool) {
 	gh.mu.Lock()
 	defer gh.mu.Unlock()
+	wasFinished := gh.g.WinningTeam != nil
 	ok := fn(gh.g)
 	if !ok {
 		// game wasn't updated
 		return
 	}
 
+	gh.g.Version++
 	gh.marshaled = nil
 	ch := gh.updated
 	gh.updated = make(chan struct{})
@@ -89,10 +146,31 @@ func (gh *GameHandle) update(fn func(*Game) bool) {
 	if err != nil {
 		log.Printf("Unable to write updated game %q to disk: %s\n", gh.g.ID, err)
 	}
+	if !wasFinished && gh.g.WinningTeam != nil {
+		err = gh.store.Archive(gh.g)
+		if err != nil {
+			log.Printf("Unable to archive game %q: %s\n", gh.g.ID, err)
+		}
+		if gh.room != nil {
+			g := gh.g
+			gh.room.update(func(r *Room) bool {
+				r.Scoreboard.record(*g.WinningTeam, g.endedByAssassin(), g.Round+1)
+				return true
+			})
+		}
+	}
 
 	close(ch)
 }
 
+// setRoom moves the game into room rh.
+func (gh *GameHandle) setRoom(rh *RoomHandle) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	gh.room = rh
+	gh.marshaled = nil
+}
+
 func (gh *GameHandle) gameStateChanged(stateID *string) (updated <-chan struct{}, replaced <-chan struct{}) {
 	if stateID == nil {
 		return closed, nil
@@ -109,17 +187,73 @@ func (gh *GameHandle) gameStateChanged(stateID *string) (updated <-chan struct{}
 // MarshalJSON implements the encoding/json.Marshaler interface.
 // It caches a marshalled value of the game object.
 func (gh *GameHandle) MarshalJSON() ([]byte, error) {
+	b, _, err := gh.marshal()
+	return b, err
+}
+
+// marshal returns the marshalled game along with the version it
+// represents.
+func (gh *GameHandle) marshal() ([]byte, uint64, error) {
 	gh.mu.Lock()
 	defer gh.mu.Unlock()
 
 	var err error
 	if gh.marshaled == nil {
+		var scoreboard *Scoreboard
+		if gh.room != nil {
+			gh.room.mu.Lock()
+			sb := gh.room.r.Scoreboard
+			gh.room.mu.Unlock()
+			scoreboard = &sb
+		}
 		gh.marshaled, err = json.Marshal(struct {
 			*Game
-			StateID string `json:"state_id"`
-		}{gh.g, gh.g.StateID()})
+			StateID    string      `json:"state_id"`
+			Scoreboard *Scoreboard `json:"scoreboard,omitempty"`
+		}{gh.g, gh.g.StateID(), scoreboard})
+	}
+	return gh.marshaled, gh.g.Version, err
+}
+
+// etag returns the HTTP entity tag of version v of a game.
+func etag(v uint64) string {
+	return `"` + strconv.FormatUint(v, 10) + `"`
+}
+
+// matchesETag reports whether the value of an If-Match or If-None-Match
+// header includes the entity tag of version v of a game.
+func matchesETag(header string, v uint64) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkState returns the status with which a mutation of g must be
+// rejected because the client's view of the game is stale, or 0 if the
+// mutation may proceed. Clients state the version they expect either in
+// an If-Match header or as the state_id of the request body. It must be
+// checked within the same update as the mutation it guards.
+func checkState(req *http.Request, stateID *string, g *Game) int {
+	if header := req.Header.Get("If-Match"); header != "" && !matchesETag(header, g.Version) {
+		return http.StatusPreconditionFailed
 	}
-	return gh.marshaled, err
+	if stateID != nil && *stateID != g.StateID() {
+		return http.StatusConflict
+	}
+	return 0
+}
+
+// gameExists returns true if there's a game or room with the given ID.
+func (s *Server) gameExists(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, game := s.games[id]
+	_, room := s.rooms[id]
+	return game || room
 }
 
 func (s *Server) getGame(gameID string) *GameHandle {
@@ -130,8 +264,25 @@ func (s *Server) getGame(gameID string) *GameHandle {
 	if ok {
 		return gh
 	}
-	gh = newHandle(newGame(gameID, randomState(s.defaultWords), GameOptions{}), s.Store)
+	words, opts := s.defaultWords, GameOptions{}
+	var tags []WordTags
+	rh, inRoom := s.rooms[gameID]
+	if inRoom {
+		var roomWords []string
+		var roomTags []WordTags
+		roomWords, roomTags, opts = rh.defaults()
+		if len(roomWords) > 0 {
+			words, tags = roomWords, roomTags
+		}
+	}
+	state := randomState(words)
+	state.Tags = tags
+	if inRoom {
+		state = state.deferring(rh.recentWords())
+	}
+	gh = s.newGameHandle(newGame(gameID, state, opts, s.Clock))
 	s.games[gameID] = gh
+	s.releaseGameID(gameID)
 	return gh
 }
 
@@ -147,6 +298,10 @@ func (s *Server) handleGameState(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if err := s.checkGameID(body.GameID); err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
 	gh := s.getGame(body.GameID)
 
 	updated, replaced := gh.gameStateChanged(body.StateID)
@@ -154,21 +309,31 @@ func (s *Server) handleGameState(rw http.ResponseWriter, req *http.Request) {
 	select {
 	case <-req.Context().Done():
 		return
-	case <-time.After(15 * time.Second):
-		writeGame(rw, gh)
+	case <-s.Clock.After(15 * time.Second):
 	case <-updated:
-		writeGame(rw, gh)
 	case <-replaced:
 		gh = s.getGame(body.GameID)
-		writeGame(rw, gh)
 	}
+
+	// Clients that already hold the current version of the game, such as
+	// those whose long poll timed out, needn't download it again.
+	if header := req.Header.Get("If-None-Match"); header != "" {
+		_, version, _ := gh.marshal()
+		if matchesETag(header, version) {
+			rw.Header().Set("ETag", etag(version))
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	writeGame(rw, gh)
 }
 
 // POST /guess
 func (s *Server) handleGuess(rw http.ResponseWriter, req *http.Request) {
 	var request struct {
-		GameID string `json:"game_id"`
-		Index  int    `json:"index"`
+		GameID  string  `json:"game_id"`
+		StateID *string `json:"state_id"`
+		Index   int     `json:"index"`
 	}
 
 	decoder := json.NewDecoder(req.Body)
@@ -176,14 +341,67 @@ func (s *Server) handleGuess(rw http.ResponseWriter, req *http.Request) {
 		http.Error(rw, "Error decoding", 400)
 		return
 	}
+	if err := s.checkGameID(request.GameID); err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
 
 	gh := s.getGame(request.GameID)
 
 	var err error
+	var rejected int
 	gh.update(func(g *Game) bool {
+		if rejected = checkState(req, request.StateID, g); rejected != 0 {
+			return false
+		}
 		err = g.Guess(request.Index)
 		return err == nil
 	})
+	if rejected != 0 {
+		writeGameStatus(rw, gh, rejected)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	writeGame(rw, gh)
+}
+
+// POST /clue
+func (s *Server) handleClue(rw http.ResponseWriter, req *http.Request) {
+	var request struct {
+		GameID  string  `json:"game_id"`
+		StateID *string `json:"state_id"`
+		Word    string  `json:"word"`
+		Number  int     `json:"number"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&request); err != nil {
+		http.Error(rw, "Error decoding", 400)
+		return
+	}
+	if err := s.checkGameID(request.GameID); err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+
+	gh := s.getGame(request.GameID)
+
+	var err error
+	var rejected int
+	gh.update(func(g *Game) bool {
+		if rejected = checkState(req, request.StateID, g); rejected != 0 {
+			return false
+		}
+		err = g.GiveClue(request.Word, request.Number)
+		return err == nil
+	})
+	if rejected != 0 {
+		writeGameStatus(rw, gh, rejected)
+		return
+	}
 	if err != nil {
 		http.Error(rw, err.Error(), 400)
 		return
@@ -194,8 +412,9 @@ func (s *Server) handleGuess(rw http.ResponseWriter, req *http.Request) {
 // POST /end-turn
 func (s *Server) handleEndTurn(rw http.ResponseWriter, req *http.Request) {
 	var request struct {
-		GameID       string `json:"game_id"`
-		CurrentRound int    `json:"current_round"`
+		GameID       string  `json:"game_id"`
+		StateID      *string `json:"state_id"`
+		CurrentRound int     `json:"current_round"`
 	}
 
 	decoder := json.NewDecoder(req.Body)
@@ -203,69 +422,216 @@ func (s *Server) handleEndTurn(rw http.ResponseWriter, req *http.Request) {
 		http.Error(rw, "Error decoding", 400)
 		return
 	}
+	if err := s.checkGameID(request.GameID); err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
 
 	gh := s.getGame(request.GameID)
 
+	var rejected int
 	gh.update(func(g *Game) bool {
+		if rejected = checkState(req, request.StateID, g); rejected != 0 {
+			return false
+		}
+		if g.WinningTeam == nil && g.Round != request.CurrentRound {
+			// The client is trying to end a turn that's already over.
+			rejected = http.StatusConflict
+			return false
+		}
 		return g.NextTurn(request.CurrentRound)
 	})
+	if rejected != 0 {
+		writeGameStatus(rw, gh, rejected)
+		return
+	}
 	writeGame(rw, gh)
 }
 
 func (s *Server) handleNextGame(rw http.ResponseWriter, req *http.Request) {
 	var request struct {
-		GameID          string   `json:"game_id"`
-		WordSet         []string `json:"word_set"`
-		CreateNew       bool     `json:"create_new"`
-		TimerDurationMS int64    `json:"timer_duration_ms"`
-		EnforceTimer    bool     `json:"enforce_timer"`
+		GameID          string       `json:"game_id"`
+		WordSet         []string     `json:"word_set"`
+		CreateNew       bool         `json:"create_new"`
+		TimerDurationMS int64        `json:"timer_duration_ms"`
+		EnforceTimer    bool         `json:"enforce_timer"`
+		MaxDifficulty   int          `json:"max_difficulty"`
+		FamilySafe      bool         `json:"family_safe"`
+		Category        string       `json:"category"`
+		WordSets        []string     `json:"word_sets"`
+		Packs           []PackWeight `json:"packs"`
+		Language        string       `json:"language"`
+		BoardCode       string       `json:"board_code"`
+		StateID         *string      `json:"state_id"`
 	}
 
 	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
 		http.Error(rw, "Error decoding", 400)
 		return
 	}
-	wordSet := map[string]bool{}
-	for _, w := range request.WordSet {
-		wordSet[strings.TrimSpace(strings.ToUpper(w))] = true
+	named, err := s.wordSets.Named(request.WordSets)
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	if request.Language == "" {
+		request.Language = s.wordSets.Language(request.WordSets)
 	}
-	if len(wordSet) > 0 && len(wordSet) < 25 {
-		http.Error(rw, "Need at least 25 words", 400)
+	wordSet, err := normalizeWordSet(append(named[:len(named):len(named)], request.WordSet...), request.Language)
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
 		return
 	}
+	wordSetTags := alignTags(wordSet, s.wordSets.Tags(request.WordSets))
+	var mixState *GameState
+	if len(request.Packs) > 0 {
+		if len(request.WordSets) > 0 || len(request.WordSet) > 0 {
+			http.Error(rw, "packs can't be combined with word_sets or word_set", 400)
+			return
+		}
+		words, mix, err := s.wordSets.Mix(request.Packs)
+		if err != nil {
+			http.Error(rw, err.Error(), 400)
+			return
+		}
+		names := make([]string, len(request.Packs))
+		for i, p := range request.Packs {
+			names[i] = p.Name
+		}
+		state := randomState(words)
+		state.Mix = mix
+		state.Tags = alignTags(words, s.wordSets.Tags(names))
+		mixState = &state
+	}
+	var boardCode *BoardCode
+	if request.BoardCode != "" {
+		bc, err := ParseBoardCode(request.BoardCode)
+		if err != nil {
+			http.Error(rw, err.Error(), 400)
+			return
+		}
+		boardCode = &bc
+	}
 
 	var gh *GameHandle
-	func() {
+	var rejected int
+	err = func() error {
 		s.mu.Lock()
 		defer s.mu.Unlock()
 
-		words := s.defaultWords
+		words, tags := s.defaultWords, []WordTags(nil)
 		if len(wordSet) > 0 {
-			words = nil
-			for w := range wordSet {
-				words = append(words, w)
-			}
-			sort.Strings(words)
+			words, tags = wordSet, wordSetTags
 		}
 
 		opts := GameOptions{
 			TimerDurationMS: request.TimerDurationMS,
 			EnforceTimer:    request.EnforceTimer,
+			MaxDifficulty:   request.MaxDifficulty,
+			FamilySafe:      request.FamilySafe,
+			Category:        strings.ToLower(strings.TrimSpace(request.Category)),
+		}
+
+		// Fall back to the room's defaults for anything the
+		// request didn't specify.
+		rh, inRoom := s.rooms[request.GameID]
+		if inRoom {
+			roomWords, roomTags, roomOpts := rh.defaults()
+			if len(wordSet) == 0 && len(roomWords) > 0 {
+				words, tags = roomWords, roomTags
+			}
+			if opts == (GameOptions{}) {
+				opts = roomOpts
+			} else if !opts.filtered() {
+				// The room's filters apply unless the request has
+				// its own.
+				opts.MaxDifficulty = roomOpts.MaxDifficulty
+				opts.FamilySafe = roomOpts.FamilySafe
+				opts.Category = roomOpts.Category
+			}
+		}
+
+		// A board code determines the exact board to play,
+		// including its options.
+		codeState := mixState
+		if boardCode != nil {
+			words, ok := s.wordSets.lookupPrefix(boardCode.WordSetID)
+			if !ok {
+				return errors.New("the board code's word set isn't known to this server")
+			}
+			if boardCode.PermIndex+wordsPerGame > len(words) {
+				return errors.New("the board code is invalid for its word set")
+			}
+			codeState = &GameState{
+				Seed:      boardCode.Seed,
+				PermIndex: boardCode.PermIndex,
+				Revealed:  make([]bool, wordsPerGame),
+				WordSet:   words,
+			}
+			opts = boardCode.GameOptions
 		}
 
 		var ok bool
 		gh, ok = s.games[request.GameID]
+		if !ok && !inRoom {
+			if err := s.validateGameID(request.GameID); err != nil {
+				return err
+			}
+		}
 		if !ok {
 			// no game exists, create for the first time
-			gh = newHandle(newGame(request.GameID, randomState(words), opts), s.Store)
+			state := randomState(words)
+			state.Tags = tags
+			if codeState != nil {
+				state = *codeState
+			}
+			if err := state.checkFilters(opts); err != nil {
+				return err
+			}
+			if inRoom && boardCode == nil {
+				state = state.deferring(rh.recentWords())
+			}
+			gh = s.newGameHandle(newGame(request.GameID, state, opts, s.Clock))
 			s.games[request.GameID] = gh
+			s.releaseGameID(request.GameID)
 		} else if request.CreateNew {
+			gh.mu.Lock()
+			rejected = checkState(req, request.StateID, gh.g)
+			gh.mu.Unlock()
+			if rejected != 0 {
+				return nil
+			}
+
 			replacedCh := gh.replaced
 
 			previousGame := gh.g
 
-			nextState := nextGameState(gh.g.GameState)
-			gh = newHandle(newGame(request.GameID, nextState, opts), s.Store)
+			nextState := nextGameState(gh.g.GameState, opts)
+			if codeState != nil {
+				nextState = *codeState
+			}
+			if err := nextState.checkFilters(opts); err != nil {
+				return err
+			}
+
+			if inRoom {
+				rh.update(func(r *Room) bool {
+					r.recordGame(previousGame)
+					return true
+				})
+			}
+
+			// Each new cycle through the word set in a room starts
+			// with words the room hasn't played recently.
+			if inRoom && boardCode == nil && nextState.PermIndex == 0 {
+				nextState = nextState.deferring(rh.recentWords())
+			}
+			g := newGame(request.GameID, nextState, opts, s.Clock)
+			g.Pinned = previousGame.Pinned
+			// Keep versions increasing across games, so that clients
+			// waiting on the previous game's version see the change.
+			g.Version = previousGame.Version + 1
+			gh = s.newGameHandle(g)
 			s.games[request.GameID] = gh
 
 			// signal to waiting /game-state goroutines that the
@@ -280,10 +646,91 @@ func (s *Server) handleNextGame(rw http.ResponseWriter, req *http.Request) {
 				log.Printf("Unable to delete old game %q from disk: %s\n", previousGame.ID, err)
 			}
 		}
+		return nil
 	}()
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	if rejected != 0 {
+		writeGameStatus(rw, gh, rejected)
+		return
+	}
 	writeGame(rw, gh)
 }
 
+// POST /reset-scores
+func (s *Server) handleResetScores(rw http.ResponseWriter, req *http.Request) {
+	var request struct {
+		GameID  string  `json:"game_id"`
+		StateID *string `json:"state_id"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(rw, "Error decoding", 400)
+		return
+	}
+	if err := s.checkGameID(request.GameID); err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+
+	gh := s.getGame(request.GameID)
+	var rejected int
+	var notInRoom bool
+	gh.update(func(g *Game) bool {
+		if rejected = checkState(req, request.StateID, g); rejected != 0 {
+			return false
+		}
+		if gh.room == nil {
+			notInRoom = true
+			return false
+		}
+		gh.room.update(func(r *Room) bool {
+			r.Scoreboard = Scoreboard{}
+			return true
+		})
+		g.UpdatedAt = g.now()
+		return true
+	})
+	if notInRoom {
+		http.Error(rw, "scores are only kept for games in rooms", 400)
+		return
+	}
+	if rejected != 0 {
+		writeGameStatus(rw, gh, rejected)
+		return
+	}
+	writeGame(rw, gh)
+}
+
+// normalizeWordSet canonicalizes and de-duplicates a custom word set
+// using the case mapping of the language lang, returning it sorted. An
+// empty word set is returned as nil.
+func normalizeWordSet(words []string, lang string) ([]string, error) {
+	normalized, err := canonicalWords(words, lang)
+	if err != nil {
+		return nil, err
+	}
+	if len(normalized) == 0 {
+		return nil, nil
+	}
+	if len(normalized) < 25 {
+		return nil, errors.New("Need at least 25 words")
+	}
+	return normalized, nil
+}
+
+type wordSetsResponse struct {
+	WordSets []WordSetInfo `json:"word_sets"`
+}
+
+// GET /word-sets
+//
+// Lists the named word sets that games may be created from.
+func (s *Server) handleWordSets(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, wordSetsResponse{WordSets: s.wordSets.List()})
+}
+
 type statsResponse struct {
 	GamesTotal          int   `json:"games_total"`
 	GamesInProgress     int   `json:"games_in_progress"`
@@ -293,7 +740,7 @@ type statsResponse struct {
 }
 
 func (s *Server) handleStats(rw http.ResponseWriter, req *http.Request) {
-	hourAgo := time.Now().Add(-time.Hour)
+	hourAgo := s.Clock.Now().Add(-time.Hour)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -318,38 +765,193 @@ func (s *Server) handleStats(rw http.ResponseWriter, req *http.Request) {
 	})
 }
 
+// GET /checkpoint
+//
+// Files the client already holds from an interrupted transfer may be
+// listed as `have=<name>:<hex sha256>` query parameters.
 func (s *Server) handleCheckpoint(rw http.ResponseWriter, req *http.Request) {
-	err := s.Store.Checkpoint(rw)
+	have := map[string]string{}
+	for _, v := range req.URL.Query()["have"] {
+		i := strings.LastIndexByte(v, ':')
+		if i < 0 {
+			http.Error(rw, "malformed have parameter", 400)
+			return
+		}
+		have[v[:i]] = v[i+1:]
+	}
+	err := s.Store.Checkpoint(rw, have)
 	if err != nil {
 		log.Printf("[ERROR] Write checkpoint %s\n", err)
 	}
 }
 
+// GET /changes?since=<seq>
+//
+// Streams the store's change feed as newline-delimited JSON, starting
+// after the change with sequence number `since`. The response waits for
+// new changes until the client disconnects or the stream has been idle
+// for a while, after which the client should reconnect. Clients whose
+// `since` has been trimmed from the feed, or is beyond its end, are
+// answered with 410 Gone and must bootstrap again.
+func (s *Server) handleChanges(rw http.ResponseWriter, req *http.Request) {
+	since, err := strconv.ParseUint(req.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		http.Error(rw, "invalid since parameter", 400)
+		return
+	}
+	flusher, _ := rw.(http.Flusher)
+	enc := json.NewEncoder(rw)
+
+	wroteHeader := false
+	for {
+		changes, changed, err := s.Store.Changes(since, 1000)
+		if (err == ErrChangesTruncated || err == ErrChangesAhead) && !wroteHeader {
+			http.Error(rw, err.Error(), http.StatusGone)
+			return
+		} else if err != nil {
+			log.Printf("[ERROR] Reading change feed: %s\n", err)
+			if !wroteHeader {
+				http.Error(rw, err.Error(), 500)
+			}
+			return
+		}
+		if !wroteHeader {
+			rw.Header().Set("Content-Type", "application/x-ndjson")
+			wroteHeader = true
+		}
+		for _, c := range changes {
+			if err := enc.Encode(c); err != nil {
+				return
+			}
+			since = c.Seq
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if len(changes) > 0 {
+			continue
+		}
+
+		select {
+		case <-req.Context().Done():
+			return
+		case <-s.Clock.After(30 * time.Second):
+			return
+		case <-changed:
+		}
+	}
+}
+
+type quarantineResponse struct {
+	Count   int                 `json:"count"`
+	Records []QuarantinedRecord `json:"records"`
+}
+
+// GET /admin/quarantine
+func (s *Server) handleQuarantine(rw http.ResponseWriter, req *http.Request) {
+	records, err := s.Store.Quarantined()
+	if err != nil {
+		http.Error(rw, "unable to read quarantine: "+err.Error(), 500)
+		return
+	}
+	writeJSON(rw, quarantineResponse{
+		Count:   len(records),
+		Records: records,
+	})
+}
+
+// POST /admin/pin
+//
+// Pinned games are exempt from the retention policy.
+func (s *Server) handlePin(rw http.ResponseWriter, req *http.Request) {
+	var request struct {
+		GameID  string  `json:"game_id"`
+		StateID *string `json:"state_id"`
+		Pinned  bool    `json:"pinned"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(rw, "Error decoding", 400)
+		return
+	}
+	if err := s.checkGameID(request.GameID); err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+
+	gh := s.getGame(request.GameID)
+	var rejected int
+	gh.update(func(g *Game) bool {
+		if rejected = checkState(req, request.StateID, g); rejected != 0 {
+			return false
+		}
+		if g.Pinned == request.Pinned {
+			return false
+		}
+		g.Pinned = request.Pinned
+		return true
+	})
+	if rejected != 0 {
+		writeGameStatus(rw, gh, rejected)
+		return
+	}
+	writeGame(rw, gh)
+}
+
+// cleanupOldGames removes games that have expired under the retention
+// policy from memory and from the store.
 func (s *Server) cleanupOldGames() {
+	now := s.Clock.Now()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	for id, rh := range s.rooms {
+		rh.mu.Lock()
+		expired := s.Retention.RoomExpired(rh.r, now)
+		if expired {
+			delete(s.rooms, id)
+			if err := s.Store.DeleteRoom(rh.r); err != nil {
+				log.Printf("Unable to delete expired room %q from disk: %s\n", id, err)
+			}
+			log.Printf("Removed expired room %s\n", id)
+		}
+		rh.mu.Unlock()
+		if gh, ok := s.games[id]; ok && expired {
+			gh.setRoom(nil)
+		}
+	}
 	for id, gh := range s.games {
+		if _, ok := s.rooms[id]; ok {
+			// A room's current game lives as long as the room.
+			continue
+		}
 		gh.mu.Lock()
-		if gh.g.WinningTeam != nil && gh.g.CreatedAt.Add(3*time.Hour).Before(time.Now()) {
-			delete(s.games, id)
-			log.Printf("Removed completed game %s\n", id)
-		} else if gh.g.CreatedAt.Add(72 * time.Hour).Before(time.Now()) {
+		if s.Retention.Expired(gh.g, now) {
 			delete(s.games, id)
+			if err := s.Store.Delete(gh.g); err != nil {
+				log.Printf("Unable to delete expired game %q from disk: %s\n", id, err)
+			}
 			log.Printf("Removed expired game %s\n", id)
 		}
 		gh.mu.Unlock()
 	}
 }
 
-func (s *Server) Start(games map[string]*Game) error {
+func (s *Server) Start(games map[string]*Game, rooms map[string]*Room, packs []*WordPack) error {
 	gameIDs, err := dictionary.Load("assets/game-id-words.txt")
 	if err != nil {
 		return err
 	}
+	blocklist, err := dictionary.Load("assets/game-id-blocklist.txt")
+	if err != nil {
+		return err
+	}
 	d, err := dictionary.Load("assets/original.txt")
 	if err != nil {
 		return err
 	}
+	if err := s.wordSets.LoadDir("assets/wordsets"); err != nil {
+		return err
+	}
 	s.tpl, err = template.New("index").Parse(tpl)
 	if err != nil {
 		return err
@@ -358,46 +960,99 @@ func (s *Server) Start(games map[string]*Game) error {
 	s.mux = http.NewServeMux()
 	s.mux.HandleFunc("/stats", s.handleStats)
 	s.mux.HandleFunc("/next-game", s.handleNextGame)
+	s.mux.HandleFunc("/word-sets", s.handleWordSets)
+	s.mux.HandleFunc("/word-packs", s.handleWordPacks)
 	s.mux.HandleFunc("/end-turn", s.handleEndTurn)
 	s.mux.HandleFunc("/guess", s.handleGuess)
+	s.mux.HandleFunc("/clue", s.handleClue)
+	s.mux.HandleFunc("/reset-scores", s.handleResetScores)
 	s.mux.HandleFunc("/game-state", s.handleGameState)
+	s.mux.HandleFunc("/room", s.handleRoom)
+	s.mux.HandleFunc("/join-room", s.handleJoinRoom)
+	s.mux.HandleFunc("/claim-room", s.handleClaimRoom)
+	s.mux.HandleFunc("/room-qr", s.handleRoomQR)
+	s.mux.HandleFunc("/print", s.handlePrint)
+	s.mux.HandleFunc("/history", s.handleHistory)
+	s.mux.HandleFunc("/history/game", s.handleHistoryGame)
+	s.mux.HandleFunc("/history/replay", s.handleHistoryReplay)
+	s.mux.HandleFunc("/replay", s.handleReplay)
 	s.mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("frontend/dist"))))
 	s.mux.HandleFunc("/", s.handleIndex)
 
 	bootstrapPW := os.Getenv("BOOTSTRAPPW")
-	// If no bootstrap PW is set, don't expose the checkpoint endpoint so we
-	// don't default to open.
+	// If no bootstrap PW is set, don't expose the checkpoint or admin
+	// endpoints so we don't default to open.
 	if bootstrapPW != "" {
-		log.Printf("/checkpoint endpoint enabled\n")
+		log.Printf("/checkpoint, /changes and /admin/ endpoints enabled\n")
 		s.mux.Handle("/checkpoint", basicAuth(
 			http.HandlerFunc(s.handleCheckpoint),
 			os.Getenv("BOOTSTRAPPW"),
 			"admin"))
+		s.mux.Handle("/changes", basicAuth(
+			http.HandlerFunc(s.handleChanges),
+			bootstrapPW,
+			"admin"))
+		s.mux.Handle("/admin/pin", basicAuth(
+			http.HandlerFunc(s.handlePin),
+			bootstrapPW,
+			"admin"))
+		s.mux.Handle("/admin/quarantine", basicAuth(
+			http.HandlerFunc(s.handleQuarantine),
+			bootstrapPW,
+			"admin"))
 	}
 
 	gameIDs = dictionary.Filter(gameIDs, func(s string) bool { return len(s) >= 3 })
-	s.gameIDWords = gameIDs.Words()
-	for i, w := range s.gameIDWords {
-		s.gameIDWords[i] = strings.ToLower(w)
+	s.gameIDs = NewIDGenerator(gameIDs.Words(), blocklist.Words())
+	switch s.GameIDFormat {
+	case "":
+	case IDFormatWords, IDFormatCode:
+		s.gameIDs.Format = s.GameIDFormat
+	default:
+		return errors.New("unknown game ID format " + string(s.GameIDFormat))
 	}
+	if s.GameIDEntropy > 0 {
+		s.gameIDs.Entropy = s.GameIDEntropy
+	}
+	s.gameIDs.Taken = s.gameExists
 
 	s.games = make(map[string]*GameHandle)
+	s.rooms = make(map[string]*RoomHandle)
 	s.defaultWords = d.Words()
 	sort.Strings(s.defaultWords)
+	// Intern the default words up front, so that board codes for them can
+	// be resolved before any game has used them.
+	if id, words, err := s.wordSets.Canonicalize(s.defaultWords); err == nil && equalWords(words, s.defaultWords) {
+		s.wordSets.mu.Lock()
+		s.defaultWords = s.wordSets.intern(id, words)
+		s.wordSets.mu.Unlock()
+	}
 	s.Server.Handler = withPProfHandler(s)
 
 	if s.Store == nil {
 		s.Store = discardStore{}
 	}
+	if s.Clock == nil {
+		s.Clock = SystemClock
+	}
+	s.gameIDs.Clock = s.Clock
 
+	for _, p := range packs {
+		if _, err := s.wordSets.addPack(p); err != nil {
+			log.Printf("Unable to load word pack %q: %s\n", p.ID, err)
+		}
+	}
+	for _, r := range rooms {
+		s.rooms[r.ID] = newRoomHandle(r, s.Store, s.Clock)
+	}
 	if games != nil {
 		for _, g := range games {
-			s.games[g.ID] = newHandle(g, s.Store)
+			s.games[g.ID] = s.newGameHandle(g)
 		}
 	}
 
 	go func() {
-		for range time.Tick(10 * time.Minute) {
+		for range s.Clock.Tick(10 * time.Minute) {
 			s.cleanupOldGames()
 		}
 	}()
@@ -447,7 +1102,22 @@ func basicAuth(handler http.Handler, password, realm string) http.Handler {
 }
 
 func writeGame(rw http.ResponseWriter, gh *GameHandle) {
-	writeJSON(rw, gh)
+	writeGameStatus(rw, gh, http.StatusOK)
+}
+
+// writeGameStatus writes the game with the given status code, tagged
+// with its version so that clients can make conditional requests.
+func writeGameStatus(rw http.ResponseWriter, gh *GameHandle, status int) {
+	j, version, err := gh.marshal()
+	if err != nil {
+		http.Error(rw, "unable to marshal response: "+err.Error(), 500)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("ETag", etag(version))
+	rw.WriteHeader(status)
+	rw.Write(j)
 }
 
 func writeJSON(rw http.ResponseWriter, resp interface{}) {
@@ -0,0 +1,407 @@
+package codenames
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestServer returns a Server that can serve requests without
+// listening on a port.
+func newTestServer(store Store) *Server {
+	s := &Server{
+		Store:        store,
+		Retention:    DefaultRetentionPolicy,
+		Clock:        SystemClock,
+		games:        make(map[string]*GameHandle),
+		rooms:        make(map[string]*RoomHandle),
+		defaultWords: testWords,
+	}
+	if s.Store == nil {
+		s.Store = discardStore{}
+	}
+	return s
+}
+
+// do sends a request with a JSON body to handler and decodes the
+// response into resp, if the status is 200.
+func do(t *testing.T, handler http.HandlerFunc, method string, body interface{}, resp interface{}) int {
+	t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, "/", bytes.NewReader(b))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code == 200 && resp != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatalf("decoding response %q: %s", rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestRoomScoreboard(t *testing.T) {
+	s := newTestServer(nil)
+	do(t, s.handleRoom, "POST", map[string]string{"room_id": "league"}, nil)
+
+	for i := 0; i < 2; i++ {
+		gh := s.getGame("league")
+		gh.update(func(g *Game) bool {
+			playToWin(t, g, Blue)
+			return true
+		})
+		do(t, s.handleNextGame, "POST", map[string]interface{}{
+			"game_id":    "league",
+			"create_new": true,
+		}, nil)
+	}
+
+	var g struct {
+		Scoreboard *Scoreboard `json:"scoreboard"`
+	}
+	do(t, s.handleGameState, "POST", map[string]string{"game_id": "league"}, &g)
+	if g.Scoreboard == nil || g.Scoreboard.BlueWins != 2 || g.Scoreboard.Streak != 2 {
+		t.Fatalf("room's scoreboard wasn't kept across games: %+v", g.Scoreboard)
+	}
+	if rh := s.rooms["league"]; rh.r.Scoreboard != *g.Scoreboard {
+		t.Errorf("room's scoreboard %+v, game's %+v", rh.r.Scoreboard, *g.Scoreboard)
+	}
+
+	do(t, s.handleResetScores, "POST", map[string]string{"game_id": "league"}, &g)
+	if g.Scoreboard == nil || *g.Scoreboard != (Scoreboard{}) {
+		t.Errorf("scoreboard wasn't reset: %+v", g.Scoreboard)
+	}
+
+	// Games outside rooms don't keep scores.
+	gh := s.getGame("pickup")
+	gh.update(func(g *Game) bool {
+		playToWin(t, g, Red)
+		return true
+	})
+	g.Scoreboard = nil
+	do(t, s.handleGameState, "POST", map[string]string{"game_id": "pickup"}, &g)
+	if g.Scoreboard != nil {
+		t.Errorf("game outside a room has a scoreboard: %+v", g.Scoreboard)
+	}
+	if code := do(t, s.handleResetScores, "POST", map[string]string{"game_id": "pickup"}, nil); code != 400 {
+		t.Errorf("resetting the scores of a game outside a room: status %d, expected 400", code)
+	}
+}
+
+func decodeRecorder(t *testing.T, rec *httptest.ResponseRecorder, resp interface{}) {
+	t.Helper()
+	if rec.Code != 200 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatalf("decoding response %q: %s", rec.Body.String(), err)
+	}
+}
+
+func TestFakeClockDrivesServer(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 11, 14, 18, 0, 0, 0, time.UTC))
+	s := newTestServer(nil)
+	s.Clock = clock
+
+	gh := s.getGame("clockwork")
+	if !gh.g.CreatedAt.Equal(clock.Now()) {
+		t.Errorf("game created at %s, expected %s", gh.g.CreatedAt, clock.Now())
+	}
+
+	// A long poll for an unchanged game returns once its timeout
+	// elapses on the server's clock.
+	stateID := gh.g.StateID()
+	b, err := json.Marshal(map[string]interface{}{"game_id": "clockwork", "state_id": stateID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		s.handleGameState(rec, httptest.NewRequest("POST", "/game-state", bytes.NewReader(b)))
+		done <- rec
+	}()
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("long poll returned before its timeout")
+	default:
+	}
+	clock.Advance(15 * time.Second)
+	if rec := <-done; rec.Code != 200 {
+		t.Fatalf("long poll status %d", rec.Code)
+	}
+
+	// The game expires once it's been idle for longer than the
+	// retention policy allows.
+	clock.Advance(s.Retention.Idle - time.Minute)
+	s.cleanupOldGames()
+	if _, ok := s.games["clockwork"]; !ok {
+		t.Fatal("game removed before it expired")
+	}
+	clock.Advance(2 * time.Minute)
+	s.cleanupOldGames()
+	if _, ok := s.games["clockwork"]; ok {
+		t.Fatal("expired game wasn't removed")
+	}
+}
+
+func TestGameVersions(t *testing.T) {
+	s := newTestServer(nil)
+	gh := s.getGame("versions")
+	if gh.g.Version != 1 {
+		t.Fatalf("new game has version %d, expected 1", gh.g.Version)
+	}
+
+	// Updates in the same instant still produce distinct state IDs.
+	clock := NewFakeClock(gh.g.UpdatedAt)
+	gh.g.clock = clock
+	stateID := gh.g.StateID()
+	do(t, s.handleEndTurn, "POST", map[string]interface{}{"game_id": "versions", "current_round": 0}, nil)
+	if gh.g.StateID() == stateID {
+		t.Fatalf("state ID %q unchanged by an update", stateID)
+	}
+
+	// A mutation conditioned on a stale version is rejected.
+	req := httptest.NewRequest("POST", "/end-turn", bytes.NewReader([]byte(`{"game_id": "versions", "current_round": 1}`)))
+	req.Header.Set("If-Match", etag(1))
+	rec := httptest.NewRecorder()
+	s.handleEndTurn(rec, req)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match: status %d, expected %d", rec.Code, http.StatusPreconditionFailed)
+	}
+	if rec.Header().Get("ETag") != etag(2) {
+		t.Errorf("ETag %q, expected %q", rec.Header().Get("ETag"), etag(2))
+	}
+	if gh.g.Round != 1 {
+		t.Errorf("game advanced to round %d despite a failed precondition", gh.g.Round)
+	}
+
+	req = httptest.NewRequest("POST", "/end-turn", bytes.NewReader([]byte(`{"game_id": "versions", "current_round": 1}`)))
+	req.Header.Set("If-Match", etag(2))
+	rec = httptest.NewRecorder()
+	s.handleEndTurn(rec, req)
+	if rec.Code != 200 || gh.g.Round != 2 || gh.g.Version != 3 {
+		t.Fatalf("matching If-Match: status %d, round %d, version %d", rec.Code, gh.g.Round, gh.g.Version)
+	}
+
+	// Replacing the game keeps versions increasing.
+	var next Game
+	do(t, s.handleNextGame, "POST", map[string]interface{}{"game_id": "versions", "create_new": true}, &next)
+	if next.Version != 4 {
+		t.Errorf("next game has version %d, expected 4", next.Version)
+	}
+
+	// Clients holding the current version aren't sent it again.
+	req = httptest.NewRequest("POST", "/game-state", bytes.NewReader([]byte(`{"game_id": "versions"}`)))
+	req.Header.Set("If-None-Match", etag(4))
+	rec = httptest.NewRecorder()
+	s.handleGameState(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status %d, expected %d", rec.Code, http.StatusNotModified)
+	}
+}
+
+func TestStaleMutationsConflict(t *testing.T) {
+	s := newTestServer(nil)
+	gh := s.getGame("conflict")
+	stateID := gh.g.StateID()
+
+	// Two players guess different cards from the same state. Only the
+	// first guess is applied.
+	first, second := guessable(gh.g, gh.g.CurrentTeam()), -1
+	for i := range gh.g.Layout {
+		if i != first {
+			second = i
+			break
+		}
+	}
+	code := do(t, s.handleGuess, "POST", map[string]interface{}{
+		"game_id": "conflict", "state_id": stateID, "index": first,
+	}, nil)
+	if code != 200 {
+		t.Fatalf("first guess: status %d", code)
+	}
+	var current Game
+	req := httptest.NewRequest("POST", "/guess", bytes.NewReader(mustMarshal(t, map[string]interface{}{
+		"game_id": "conflict", "state_id": stateID, "index": second,
+	})))
+	rec := httptest.NewRecorder()
+	s.handleGuess(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("second guess: status %d, expected %d", rec.Code, http.StatusConflict)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &current); err != nil {
+		t.Fatal(err)
+	}
+	if current.StateID() != gh.g.StateID() || !current.Revealed[first] || current.Revealed[second] {
+		t.Errorf("conflict returned a stale game: %+v", current.Revealed)
+	}
+
+	// An end-turn for a round that's already over is rejected, whether
+	// or not the client sends its state.
+	do(t, s.handleEndTurn, "POST", map[string]interface{}{
+		"game_id": "conflict", "current_round": gh.g.Round,
+	}, nil)
+	round := gh.g.Round
+	code = do(t, s.handleEndTurn, "POST", map[string]interface{}{
+		"game_id": "conflict", "current_round": 0,
+	}, nil)
+	if code != http.StatusConflict {
+		t.Errorf("stale end-turn: status %d, expected %d", code, http.StatusConflict)
+	}
+	if gh.g.Round != round {
+		t.Errorf("stale end-turn ended round %d", round)
+	}
+
+	// Only one of two simultaneous requests for the next game applies.
+	stateID = gh.g.StateID()
+	for i, want := range []int{200, http.StatusConflict} {
+		code = do(t, s.handleNextGame, "POST", map[string]interface{}{
+			"game_id": "conflict", "create_new": true, "state_id": stateID,
+		}, nil)
+		if code != want {
+			t.Errorf("next game request %d: status %d, expected %d", i, code, want)
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestNextGameFromNamedWordSets(t *testing.T) {
+	s := newTestServer(nil)
+	if err := s.wordSets.LoadDir("assets/wordsets"); err != nil {
+		t.Fatal(err)
+	}
+
+	var list wordSetsResponse
+	if code := do(t, s.handleWordSets, "GET", nil, &list); code != 200 || len(list.WordSets) == 0 {
+		t.Fatalf("GET /word-sets: status %d, %d word sets", code, len(list.WordSets))
+	}
+
+	var g Game
+	code := do(t, s.handleNextGame, "POST", map[string]interface{}{
+		"game_id":   "polyglot",
+		"word_sets": []string{"Greek"},
+	}, &g)
+	if code != 200 {
+		t.Fatalf("status %d", code)
+	}
+	greek, _ := s.wordSets.Named([]string{"Greek"})
+	if !equalWords(g.WordSet, greek) {
+		t.Error("game wasn't created from the Greek word set")
+	}
+	if &s.games["polyglot"].g.WordSet[0] != &greek[0] {
+		t.Error("game's word set wasn't interned")
+	}
+
+	code = do(t, s.handleNextGame, "POST", map[string]interface{}{
+		"game_id":   "unknown",
+		"word_sets": []string{"Klingon"},
+	}, nil)
+	if code != 400 {
+		t.Errorf("unknown word set: status %d, expected 400", code)
+	}
+}
+
+func TestNextGameFromMixedPacks(t *testing.T) {
+	s := newTestServer(nil)
+	if err := s.wordSets.LoadDir("assets/wordsets"); err != nil {
+		t.Fatal(err)
+	}
+	french, _ := s.wordSets.Named([]string{"French"})
+	isFrench := map[string]bool{}
+	for _, w := range french {
+		isFrench[w] = true
+	}
+
+	packs := []PackWeight{
+		{Name: "French", Weight: 7},
+		{Name: "Italian", Weight: 3, Min: 10},
+	}
+	var g Game
+	code := do(t, s.handleNextGame, "POST", map[string]interface{}{
+		"game_id": "mixed",
+		"packs":   packs,
+	}, &g)
+	if code != 200 {
+		t.Fatalf("status %d", code)
+	}
+	if len(g.Mix) != 2 || g.Mix[0].Count != 11 || g.Mix[1].Count != 14 {
+		t.Fatalf("unexpected mix: %+v", g.Mix)
+	}
+	if g.Mix[0].Size != len(french) || g.Mix[1].Offset != len(french) {
+		t.Errorf("unexpected mix offsets: %+v", g.Mix)
+	}
+	if g.BoardCode != "" {
+		t.Error("mixed board has a board code")
+	}
+
+	// Each board draws each pack's share of the cards, without repeating
+	// words from previous boards until a pack runs out.
+	// Words in both packs belong to French.
+	games := g.Mix[1].Size / 14
+	if len(french)/11 < games {
+		games = len(french) / 11
+	}
+	seen := map[string]bool{}
+	for i := 0; i < games; i++ {
+		var frenchCards int
+		for _, w := range g.Words {
+			if seen[w] {
+				t.Fatalf("game %d repeated %q", i, w)
+			}
+			seen[w] = true
+			if isFrench[w] {
+				frenchCards++
+			}
+		}
+		if frenchCards != 11 {
+			t.Errorf("game %d has %d French cards, expected 11", i, frenchCards)
+		}
+		if again := newGame("again", g.GameState, g.GameOptions, SystemClock); !equalWords(again.Words, g.Words) {
+			t.Fatalf("game %d isn't reproducible from its state", i)
+		}
+
+		code := do(t, s.handleNextGame, "POST", map[string]interface{}{
+			"game_id":    "mixed",
+			"create_new": true,
+		}, &g)
+		if code != 200 {
+			t.Fatalf("status %d", code)
+		}
+	}
+	if g.PermIndex != 0 {
+		t.Errorf("exhausted mix wasn't reseeded, perm index %d", g.PermIndex)
+	}
+	if len(g.Mix) != 2 {
+		t.Error("mix was lost when reseeding")
+	}
+
+	badRequests := []map[string]interface{}{
+		{"packs": []PackWeight{{Name: "French", Min: 20}, {Name: "Italian", Min: 20}}},
+		{"packs": []PackWeight{{Name: "French", Weight: -1}}},
+		{"packs": []PackWeight{{Name: "Klingon"}}},
+		{"packs": []PackWeight{{Name: "French"}}, "word_sets": []string{"Italian"}},
+	}
+	for i, body := range badRequests {
+		body["game_id"] = "bad"
+		if code := do(t, s.handleNextGame, "POST", body, nil); code != 400 {
+			t.Errorf("bad request %d: status %d, expected 400", i, code)
+		}
+	}
+}
@@ -1,16 +1,13 @@
 package codenames
 
 import (
-	"compress/gzip"
-	"encoding/gob"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"math"
-	"os"
-	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/cockroachdb/pebble"
@@ -18,9 +15,19 @@ import (
 
 // PebbleStore wraps a *pebble.DB with an implementation of the
 // Store interface, persisting games under a []byte(`/games/`)
-// key prefix.
+// key prefix. Every write is also recorded in a change feed under
+// the []byte(`/changes/`) key prefix, so that it may be replicated.
 type PebbleStore struct {
 	DB *pebble.DB
+
+	// RewriteMigrated configures Restore to write back any games
+	// that were upgraded from an older schema version, so that
+	// the migration only needs to run once.
+	RewriteMigrated bool
+
+	mu      sync.Mutex
+	seq     uint64        // sequence number of the last change
+	changed chan struct{} // closed when a change is recorded
 }
 
 // Restore loads all persisted games from storage.
@@ -32,27 +39,142 @@ func (ps *PebbleStore) Restore() (map[string]*Game, error) {
 	defer iter.Close()
 
 	games := make(map[string]*Game)
+	var migrated []*Game
+	var quarantined int
 	for _ = iter.First(); iter.Valid(); iter.Next() {
-		var g Game
-		err := json.Unmarshal(iter.Value(), &g)
+		g, upgraded, err := decodeGame(iter.Value())
 		if err != nil {
-			return nil, fmt.Errorf("Unmarshal game: %w", err)
+			// Don't let a single bad record prevent the rest of the
+			// games from loading. Move it out of the way so that it
+			// can be inspected later.
+			log.Printf("Quarantining undecodable game %s: %s\n", iter.Key(), err)
+			if err := ps.quarantine(iter.Key(), iter.Value(), err); err != nil {
+				return nil, fmt.Errorf("quarantine: %w", err)
+			}
+			quarantined++
+			continue
+		}
+		games[g.ID] = g
+		if upgraded {
+			migrated = append(migrated, g)
 		}
-		games[g.ID] = &g
 	}
 	if err := iter.Error(); err != nil {
 		return nil, fmt.Errorf("restore iter: %w", err)
 	}
+
+	if quarantined > 0 {
+		log.Printf("Quarantined %d undecodable games\n", quarantined)
+	}
+	if len(migrated) > 0 {
+		log.Printf("Migrated %d games to schema version %d\n", len(migrated), currentSchemaVersion)
+	}
+	if ps.RewriteMigrated {
+		for _, g := range migrated {
+			if err := ps.Save(g); err != nil {
+				return nil, fmt.Errorf("rewriting migrated game: %w", err)
+			}
+		}
+	}
 	return games, nil
 }
 
-// DeleteExpired deletes all games created before `expiry.`
-func (ps *PebbleStore) DeleteExpired(expiry time.Time) error {
-	return ps.DB.DeleteRange(
-		mkkey(0, ""),
-		mkkey(expiry.Unix(), ""),
-		nil,
-	)
+// DeleteExpired deletes all rooms and games that have expired at time
+// now under the retention policy. The current game of a room that
+// hasn't expired is kept. It returns the number of games deleted.
+func (ps *PebbleStore) DeleteExpired(policy RetentionPolicy, now time.Time) (int, error) {
+	rooms, err := ps.RestoreRooms()
+	if err != nil {
+		return 0, err
+	}
+	var deletes []Change
+	for id, r := range rooms {
+		if policy.RoomExpired(r, now) {
+			deletes = append(deletes, Change{Op: changeDelete, Key: roomKey(r.ID)})
+			delete(rooms, id)
+		}
+	}
+
+	iter := ps.DB.NewIter(&pebble.IterOptions{
+		LowerBound: []byte("/games/"),
+		UpperBound: []byte(fmt.Sprintf("/games/%019d", math.MaxInt64)),
+	})
+	defer iter.Close()
+
+	var n int
+	for _ = iter.First(); iter.Valid(); iter.Next() {
+		g, _, err := decodeGame(iter.Value())
+		if err != nil {
+			// Restore will quarantine the game.
+			continue
+		}
+		if _, ok := rooms[g.ID]; ok {
+			continue
+		}
+		if policy.Expired(g, now) {
+			deletes = append(deletes, Change{
+				Op:  changeDelete,
+				Key: append([]byte(nil), iter.Key()...),
+			})
+			n++
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return 0, fmt.Errorf("expiry iter: %w", err)
+	}
+	if err := ps.write(deletes...); err != nil {
+		return 0, err
+	}
+	_, err = ps.DeleteExpiredArchives(policy, now)
+	return n, err
+}
+
+// RestoreRooms loads all persisted rooms from storage.
+func (ps *PebbleStore) RestoreRooms() (map[string]*Room, error) {
+	iter := ps.DB.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(roomsPrefix),
+		UpperBound: []byte(roomsUpperBound),
+	})
+	defer iter.Close()
+
+	rooms := make(map[string]*Room)
+	for _ = iter.First(); iter.Valid(); iter.Next() {
+		var r Room
+		if err := json.Unmarshal(iter.Value(), &r); err != nil {
+			log.Printf("Quarantining undecodable room %s: %s\n", iter.Key(), err)
+			if err := ps.quarantine(iter.Key(), iter.Value(), err); err != nil {
+				return nil, fmt.Errorf("quarantine: %w", err)
+			}
+			continue
+		}
+		rooms[r.ID] = &r
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("restore rooms iter: %w", err)
+	}
+	return rooms, nil
+}
+
+// SaveRoom saves the room to persistent storage.
+func (ps *PebbleStore) SaveRoom(r *Room) error {
+	v, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshaling Room: %w", err)
+	}
+	err = ps.write(Change{Op: changeSet, Key: roomKey(r.ID), Value: v})
+	if err != nil {
+		return fmt.Errorf("db.Set: %w", err)
+	}
+	return nil
+}
+
+// DeleteRoom removes a room from persistent storage.
+func (ps *PebbleStore) DeleteRoom(r *Room) error {
+	err := ps.write(Change{Op: changeDelete, Key: roomKey(r.ID)})
+	if err != nil {
+		return fmt.Errorf("db.Delete: %w", err)
+	}
+	return nil
 }
 
 // Save saves the game to persistent storage.
@@ -62,7 +184,7 @@ func (ps *PebbleStore) Save(g *Game) error {
 		return fmt.Errorf("trySave: %w", err)
 	}
 
-	err = ps.DB.Set(k, v, &pebble.WriteOptions{Sync: true})
+	err = ps.write(Change{Op: changeSet, Key: k, Value: v})
 	if err != nil {
 		return fmt.Errorf("db.Set: %w", err)
 	}
@@ -72,73 +194,76 @@ func (ps *PebbleStore) Save(g *Game) error {
 // Delete removes a game from persistent storage.
 func (ps *PebbleStore) Delete(g *Game) error {
 	k := mkkey(g.CreatedAt.Unix(), g.ID)
-	err := ps.DB.Delete(k, nil)
+	err := ps.write(Change{Op: changeDelete, Key: k})
 	if err != nil {
 		return fmt.Errorf("db.Delete: %w", err)
 	}
 	return nil
 }
 
-type CheckpointFile struct {
-	Name string
-	Data []byte
+// QuarantinedRecord describes a persisted record that couldn't be
+// decoded and was moved under the []byte(`/quarantine/`) key prefix.
+type QuarantinedRecord struct {
+	Key           string    `json:"key"`
+	Value         []byte    `json:"value"`
+	Error         string    `json:"error"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
 }
 
-// Checkpoint returns a serialized represenation of the entire store.
-func (ps *PebbleStore) Checkpoint(w io.Writer) error {
-	// Compact the entire key space. The database tends to be small and there
-	// tends to be a significant number of obsolete keys, so this shouldn't be
-	// too expensive but will reduce the number of bytes we need to send over
-	// the network.
-	err := ps.DB.Compact([]byte{}, []byte{0xFF, 0xFF, 0xFF, 0xFF})
+// quarantine atomically moves the record at key to the quarantine
+// keyspace, recording the error encountered decoding it.
+func (ps *PebbleStore) quarantine(key, value []byte, decodeErr error) error {
+	v, err := json.Marshal(QuarantinedRecord{
+		Key:           string(key),
+		Value:         value,
+		Error:         decodeErr.Error(),
+		QuarantinedAt: time.Now(),
+	})
 	if err != nil {
 		return err
 	}
+	return ps.write(
+		Change{Op: changeSet, Key: quarantineKey(key), Value: v},
+		Change{Op: changeDelete, Key: append([]byte(nil), key...)},
+	)
+}
 
-	// Create a Pebble checkpoint in a temporary directory.
-	name, err := ioutil.TempDir("", "checkpoint")
-	if err != nil {
-		return err
-	}
-	if err := os.RemoveAll(name); err != nil {
-		return err
-	}
-	defer os.RemoveAll(name)
+// Quarantined returns all records that have been quarantined.
+func (ps *PebbleStore) Quarantined() ([]QuarantinedRecord, error) {
+	iter := ps.DB.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(quarantinePrefix),
+		UpperBound: []byte(quarantineUpperBound),
+	})
+	defer iter.Close()
 
-	err = ps.DB.Checkpoint(name)
-	if err != nil {
-		return err
+	var records []QuarantinedRecord
+	for _ = iter.First(); iter.Valid(); iter.Next() {
+		var r QuarantinedRecord
+		if err := json.Unmarshal(iter.Value(), &r); err != nil {
+			return nil, fmt.Errorf("Unmarshal quarantined record %s: %w", iter.Key(), err)
+		}
+		records = append(records, r)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("quarantine iter: %w", err)
 	}
+	return records, nil
+}
 
-	// Write all the files in the checkpoint out over the network.
-	gzipWriter := gzip.NewWriter(w)
-	enc := gob.NewEncoder(gzipWriter)
-	err = filepath.Walk(name, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return err
-		}
-		b, err := ioutil.ReadFile(path)
-		if err != nil {
-			return err
-		}
-		relPath, err := filepath.Rel(name, path)
-		if err != nil {
-			return err
-		}
-		log.Printf("Checkpoint sending file %s (%d bytes)\n", relPath, len(b))
-		return enc.Encode(CheckpointFile{
-			Name: relPath,
-			Data: b,
-		})
+// PurgeQuarantine permanently deletes all quarantined records.
+func (ps *PebbleStore) PurgeQuarantine() error {
+	return ps.write(Change{
+		Op:  changeDeleteRange,
+		Key: []byte(quarantinePrefix),
+		End: []byte(quarantineUpperBound),
 	})
-	if err != nil {
-		return err
-	}
-	return gzipWriter.Close()
 }
 
 func gameKV(g *Game) (key, value []byte, err error) {
-	value, err = json.Marshal(g)
+	value, err = json.Marshal(persistedGame{
+		SchemaVersion: currentSchemaVersion,
+		Game:          g,
+	})
 	if err != nil {
 		return nil, nil, fmt.Errorf("marshaling GameState: %w", err)
 	}
@@ -153,8 +278,36 @@ func mkkey(unixSecs int64, id string) []byte {
 	return []byte(fmt.Sprintf("/games/%019d/%q", unixSecs, id))
 }
 
+const (
+	roomsPrefix     = "/rooms/"
+	roomsUpperBound = "/rooms0"
+)
+
+func roomKey(id string) []byte {
+	return []byte(fmt.Sprintf("%s%q", roomsPrefix, id))
+}
+
+const (
+	quarantinePrefix     = "/quarantine/"
+	quarantineUpperBound = "/quarantine0"
+)
+
+func quarantineKey(key []byte) []byte {
+	return append([]byte(quarantinePrefix), bytes.TrimPrefix(key, []byte("/"))...)
+}
+
 type discardStore struct{}
 
-func (ds discardStore) Save(*Game) error           { return nil }
-func (ds discardStore) Delete(*Game) error         { return nil }
-func (ds discardStore) Checkpoint(io.Writer) error { return nil }
+func (ds discardStore) Save(*Game) error                              { return nil }
+func (ds discardStore) Delete(*Game) error                            { return nil }
+func (ds discardStore) Checkpoint(io.Writer, map[string]string) error { return nil }
+func (ds discardStore) Quarantined() ([]QuarantinedRecord, error)     { return nil, nil }
+func (ds discardStore) SaveRoom(*Room) error                          { return nil }
+func (ds discardStore) DeleteRoom(*Room) error                        { return nil }
+func (ds discardStore) Archive(*Game) error                           { return nil }
+func (ds discardStore) ArchivedGames(string) ([]GameSummary, error)   { return nil, nil }
+func (ds discardStore) ArchivedGame(string, string) (*Game, error)    { return nil, nil }
+func (ds discardStore) SaveWordPack(*WordPack) error                  { return nil }
+func (ds discardStore) Changes(uint64, int) ([]Change, <-chan struct{}, error) {
+	return nil, nil, nil
+}
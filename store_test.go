@@ -1,9 +1,13 @@
 package codenames
 
 import (
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/pebble"
 	"github.com/jbowens/dictionary"
@@ -28,7 +32,7 @@ func init() {
 func randomGames(n int) map[string]*Game {
 	games := make(map[string]*Game)
 	for _, w := range gameIDs[:n] {
-		games[w] = newGame(w, randomState(words), GameOptions{})
+		games[w] = newGame(w, randomState(words), GameOptions{}, SystemClock)
 	}
 	return games
 }
@@ -91,3 +95,258 @@ func TestPersist(t *testing.T) {
 
 	}
 }
+
+func TestRestoreMigrations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-migrations-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps := PebbleStore{RewriteMigrated: true}
+	ps.DB, err = pebble.Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.DB.Close()
+
+	// Write each golden fixture directly into the store, exactly
+	// as an older version of the server would have.
+	fixtures, err := filepath.Glob("testdata/schema/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range fixtures {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var g Game
+		if err := json.Unmarshal(b, &g); err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if err := ps.DB.Set(mkkey(g.CreatedAt.Unix(), g.ID), b, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	games, err := ps.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(games) != len(fixtures) {
+		t.Fatalf("restored %d games, expected %d", len(games), len(fixtures))
+	}
+
+	g := games["legacy-game"]
+	if g == nil {
+		t.Fatal("legacy-game wasn't restored")
+	}
+	if g.Round != 1 || !g.Revealed[0] || !g.Revealed[1] || g.TimerDurationMS != 60000 || !g.EnforceTimer {
+		t.Errorf("legacy-game restored incorrectly: %s", pretty.Sprint(g))
+	}
+
+	g = games["legacy-null-revealed"]
+	if g == nil {
+		t.Fatal("legacy-null-revealed wasn't restored")
+	}
+	if len(g.Revealed) != len(g.Layout) {
+		t.Errorf("expected %d revealed entries, got %d", len(g.Layout), len(g.Revealed))
+	}
+
+	g = games["v1-game"]
+	if g == nil {
+		t.Fatal("v1-game wasn't restored")
+	}
+	if g.Version != 1 || !g.Pinned {
+		t.Errorf("v1-game restored incorrectly: %s", pretty.Sprint(g))
+	}
+
+	// Restore should have rewritten the migrated games with
+	// the current schema version.
+	for _, g := range games {
+		if g.Version == 0 {
+			t.Errorf("%s: migrated game has no version", g.ID)
+		}
+		v, closer, err := ps.DB.Get(mkkey(g.CreatedAt.Unix(), g.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var header struct {
+			SchemaVersion int `json:"schema_version"`
+		}
+		err = json.Unmarshal(v, &header)
+		closer.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.SchemaVersion != currentSchemaVersion {
+			t.Errorf("%s: schema version %d, expected %d", g.ID, header.SchemaVersion, currentSchemaVersion)
+		}
+	}
+}
+
+func TestDecodeGameFutureSchema(t *testing.T) {
+	_, _, err := decodeGame([]byte(`{"schema_version": 1000, "id": "future"}`))
+	if err == nil {
+		t.Fatal("expected an error decoding a game from a future schema version")
+	}
+}
+
+func TestRestoreQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-quarantine-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ps PebbleStore
+	ps.DB, err = pebble.Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.DB.Close()
+
+	games := randomGames(2)
+	for _, g := range games {
+		if err := ps.Save(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	badKey := mkkey(1, "corrupt")
+	if err := ps.DB.Set(badKey, []byte(`{"id": "corrupt", "layout": 7`), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := ps.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != len(games) {
+		t.Errorf("restored %d games, expected %d", len(restored), len(games))
+	}
+
+	records, err := ps.Quarantined()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Key != string(badKey) || records[0].Error == "" {
+		t.Fatalf("unexpected quarantined records: %s", pretty.Sprint(records))
+	}
+	if _, closer, err := ps.DB.Get(badKey); err != pebble.ErrNotFound {
+		if err == nil {
+			closer.Close()
+		}
+		t.Errorf("expected corrupt record to be removed from /games/, got err %v", err)
+	}
+
+	// A second restore should succeed without quarantining anything else.
+	if _, err := ps.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.PurgeQuarantine(); err != nil {
+		t.Fatal(err)
+	}
+	records, err = ps.Quarantined()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected an empty quarantine after purging, found %d records", len(records))
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	src := openTestStore(t, "test-export-*")
+	defer src.DB.Close()
+
+	base := time.Date(2020, 11, 14, 18, 0, 0, 0, time.UTC)
+	games := randomGames(5)
+	var i int
+	for _, id := range gameIDs[:5] {
+		games[id].CreatedAt = base.Add(time.Duration(i) * time.Hour)
+		if err := src.Save(games[id]); err != nil {
+			t.Fatal(err)
+		}
+		i++
+	}
+
+	// Export only the games created in the second through fourth hours.
+	var buf bytes.Buffer
+	counts, err := src.Export(&buf, base.Add(time.Hour), base.Add(4*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts.Games != 3 {
+		t.Fatalf("exported %d games, expected 3", counts.Games)
+	}
+
+	dst := openTestStore(t, "test-import-*")
+	defer dst.DB.Close()
+	counts, err = dst.Import(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts.Games != 3 {
+		t.Fatalf("imported %d games, expected 3", counts.Games)
+	}
+	restored, err := dst.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j, id := range gameIDs[:5] {
+		_, ok := restored[id]
+		if want := j >= 1 && j < 4; ok != want {
+			t.Errorf("game %q (created at %s) restored = %t, expected %t", id, games[id].CreatedAt, ok, want)
+		}
+	}
+	if g := restored[gameIDs[2]]; g != nil && !reflect.DeepEqual(g.Layout, games[gameIDs[2]].Layout) {
+		t.Errorf("imported layout doesn't match: %s", pretty.Sprint(g.Layout))
+	}
+}
+
+func TestExportImportEverything(t *testing.T) {
+	src := openTestStore(t, "test-export-all-*")
+	defer src.DB.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	g := newGame("friday-night", randomState(testWords), GameOptions{}, SystemClock)
+	red := Red
+	g.WinningTeam = &red
+	room := &Room{ID: "friday-night", Owner: "Alice", ClaimHash: hashClaimToken("token"), CreatedAt: now, UpdatedAt: now}
+	pack := newWordPack(wordSetID{1, 2, 3}, "Animals", "en", testWords[:30], nil, now)
+	for _, err := range []error{src.Save(g), src.SaveRoom(room), src.Archive(g), src.SaveWordPack(pack)} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	want := BackupCounts{Games: 1, Rooms: 1, ArchivedGames: 1, WordPacks: 1}
+	if counts, err := src.Export(&buf, time.Time{}, time.Time{}); err != nil || counts != want {
+		t.Fatalf("exported %s, %v", counts, err)
+	}
+	dst := openTestStore(t, "test-import-all-*")
+	defer dst.DB.Close()
+	if counts, err := dst.Import(&buf); err != nil || counts != want {
+		t.Fatalf("imported %s, %v", counts, err)
+	}
+
+	rooms, err := dst.RestoreRooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := rooms[room.ID]; r == nil || r.Owner != "Alice" || r.ClaimHash != room.ClaimHash {
+		t.Errorf("restored room %+v", r)
+	}
+	archived, err := dst.ArchivedGames(g.ID)
+	if err != nil || len(archived) != 1 {
+		t.Fatalf("restored %d archived games, %v", len(archived), err)
+	}
+	packs, err := dst.RestoreWordPacks()
+	if err != nil || len(packs) != 1 || packs[0].Name != "Animals" || len(packs[0].Words) != 30 {
+		t.Errorf("restored word packs %+v, %v", packs, err)
+	}
+	games, err := dst.Restore()
+	if err != nil || games[g.ID] == nil {
+		t.Errorf("game wasn't restored: %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package codenames
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/cases"
+)
+
+// Content ratings of words.
+const (
+	// RatingFamily marks words that are safe for children and
+	// classrooms.
+	RatingFamily = "family"
+	// RatingMature marks words that aren't.
+	RatingMature = "mature"
+)
+
+// maxDifficulty is the hardest difficulty a word can be tagged with.
+// Difficulties start at 1; zero means the difficulty is unknown.
+const maxDifficulty = 5
+
+// WordTags describes a single word of a word pack.
+type WordTags struct {
+	Difficulty int      `json:"difficulty,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+	Rating     string   `json:"rating,omitempty"`
+	Language   string   `json:"language,omitempty"`
+}
+
+func (t WordTags) validate() error {
+	if t.Difficulty < 0 || t.Difficulty > maxDifficulty {
+		return fmt.Errorf("difficulty must be between 1 and %d", maxDifficulty)
+	}
+	switch t.Rating {
+	case "", RatingFamily, RatingMature:
+	default:
+		return fmt.Errorf("unknown rating %q", t.Rating)
+	}
+	if _, err := parseLanguage(t.Language); err != nil {
+		return err
+	}
+	return nil
+}
+
+// merge combines the tags of two spellings of the same word. The result
+// is the more conservative of the two: the harder difficulty, the less
+// permissive rating, and every category of either.
+func (t WordTags) merge(o WordTags) WordTags {
+	if o.Difficulty > t.Difficulty {
+		t.Difficulty = o.Difficulty
+	}
+	if t.Rating != o.Rating && (t.Rating == RatingFamily || o.Rating == RatingMature) {
+		t.Rating = o.Rating
+	}
+	if t.Language == "" {
+		t.Language = o.Language
+	}
+	categories := map[string]bool{}
+	for _, c := range append(t.Categories[:len(t.Categories):len(t.Categories)], o.Categories...) {
+		categories[c] = true
+	}
+	t.Categories = t.Categories[:0:0]
+	for c := range categories {
+		t.Categories = append(t.Categories, c)
+	}
+	sort.Strings(t.Categories)
+	return t
+}
+
+// parseTaggedWords decodes the words of the extended word pack format,
+// in which each word is either a string or an object with the word and
+// its tags:
+//
+//	["AGENT", {"word": "ALIEN", "difficulty": 2, "categories": ["space"], "rating": "family"}]
+//
+// The returned tags are nil if no word was tagged.
+func parseTaggedWords(raw []json.RawMessage) ([]string, []WordTags, error) {
+	words := make([]string, 0, len(raw))
+	tags := make([]WordTags, 0, len(raw))
+	var tagged bool
+	for i, r := range raw {
+		var w string
+		if err := json.Unmarshal(r, &w); err == nil {
+			words = append(words, w)
+			tags = append(tags, WordTags{})
+			continue
+		}
+		var entry struct {
+			Word string `json:"word"`
+			WordTags
+		}
+		if err := json.Unmarshal(r, &entry); err != nil {
+			return nil, nil, fmt.Errorf("word %d must be a string or an object", i+1)
+		}
+		if err := entry.validate(); err != nil {
+			return nil, nil, fmt.Errorf("word %d: %w", i+1, err)
+		}
+		for j, c := range entry.Categories {
+			entry.Categories[j] = strings.ToLower(strings.TrimSpace(c))
+		}
+		words = append(words, entry.Word)
+		tags = append(tags, entry.WordTags)
+		tagged = true
+	}
+	if !tagged {
+		tags = nil
+	}
+	return words, tags, nil
+}
+
+// canonicalTags maps the canonical form of each word to its tags,
+// merging the tags of words with the same canonical form. It returns nil
+// if tags is empty.
+func canonicalTags(words []string, tags []WordTags, lang string) (map[string]WordTags, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	if len(tags) != len(words) {
+		return nil, errors.New("every word must have tags")
+	}
+	tag, err := parseLanguage(lang)
+	if err != nil {
+		return nil, err
+	}
+	upper := cases.Upper(tag)
+
+	byWord := make(map[string]WordTags, len(words))
+	for i, w := range words {
+		w = canonicalWord(w, upper)
+		if t, ok := byWord[w]; ok {
+			byWord[w] = t.merge(tags[i])
+		} else {
+			byWord[w] = tags[i]
+		}
+	}
+	return byWord, nil
+}
+
+// alignTags returns the tags of each of words, or nil if none of them
+// are tagged.
+func alignTags(words []string, byWord map[string]WordTags) []WordTags {
+	if len(byWord) == 0 {
+		return nil
+	}
+	tags := make([]WordTags, len(words))
+	var tagged bool
+	for i, w := range words {
+		t, ok := byWord[w]
+		tags[i] = t
+		tagged = tagged || ok
+	}
+	if !tagged {
+		return nil
+	}
+	return tags
+}
+
+// filtered returns true if opts restricts which words may appear on a
+// board.
+func (opts GameOptions) filtered() bool {
+	return opts.MaxDifficulty > 0 || opts.FamilySafe || opts.Category != ""
+}
+
+// allows returns true if a word with tags t may appear on a board. Words
+// without tags never pass a filter, since nothing is known about them.
+func (opts GameOptions) allows(t WordTags) bool {
+	if opts.MaxDifficulty > 0 && (t.Difficulty == 0 || t.Difficulty > opts.MaxDifficulty) {
+		return false
+	}
+	if opts.FamilySafe && t.Rating != RatingFamily {
+		return false
+	}
+	if opts.Category != "" {
+		for _, c := range t.Categories {
+			if c == opts.Category {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// filter returns the state with its word set restricted to the words
+// opts allows. If the game's place in its permutation is no longer
+// valid, the permutation starts over.
+func (state GameState) filter(opts GameOptions) GameState {
+	if !opts.filtered() {
+		return state
+	}
+	allowed := func(i int) bool {
+		return i < len(state.Tags) && opts.allows(state.Tags[i])
+	}
+	var removed bool
+	for i := range state.WordSet {
+		if !allowed(i) {
+			removed = true
+			break
+		}
+	}
+	if !removed {
+		return state
+	}
+
+	words := make([]string, 0, len(state.WordSet))
+	tags := make([]WordTags, 0, len(state.WordSet))
+	keep := func(from, to int) {
+		for i := from; i < to; i++ {
+			if allowed(i) {
+				words = append(words, state.WordSet[i])
+				tags = append(tags, state.Tags[i])
+			}
+		}
+	}
+	if len(state.Mix) == 0 {
+		keep(0, len(state.WordSet))
+	} else {
+		mix := make([]PackShare, len(state.Mix))
+		for i, share := range state.Mix {
+			share.Offset = len(words)
+			keep(state.Mix[i].Offset, state.Mix[i].Offset+state.Mix[i].Size)
+			share.Size = len(words) - share.Offset
+			mix[i] = share
+		}
+		state.Mix = mix
+	}
+	state.WordSet, state.Tags = words, tags
+	if state.PermIndex+wordsPerGame > len(words) || state.validMix() != nil {
+		state.PermIndex = 0
+	}
+	return state
+}
+
+// checkFilters returns an error if there aren't enough words allowed by
+// opts to fill a board.
+func (state GameState) checkFilters(opts GameOptions) error {
+	if !opts.filtered() {
+		return nil
+	}
+	state = state.filter(opts)
+	if len(state.WordSet) < wordsPerGame {
+		return fmt.Errorf("only %d words match the game's filters", len(state.WordSet))
+	}
+	for _, share := range state.Mix {
+		if share.Count > share.Size {
+			return fmt.Errorf("only %d words of %q match the game's filters", share.Size, share.Name)
+		}
+	}
+	return nil
+}
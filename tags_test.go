@@ -0,0 +1,251 @@
+package codenames
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// taggedPack returns a word pack in the JSON format with n words of each
+// difficulty from 1 to 3. Words of difficulty 1 are family-safe, and
+// words of difficulty 3 are mature.
+func taggedPack(n int) string {
+	var words []interface{}
+	for d := 1; d <= 3; d++ {
+		for i := 0; i < n; i++ {
+			tags := map[string]interface{}{
+				"word":       fmt.Sprintf("word %d-%d", d, i),
+				"difficulty": d,
+				"categories": []string{"Level " + fmt.Sprint(d)},
+			}
+			switch d {
+			case 1:
+				tags["rating"] = RatingFamily
+			case 3:
+				tags["rating"] = RatingMature
+			}
+			words = append(words, tags)
+		}
+	}
+	words = append(words, "untagged")
+	b, _ := json.Marshal(map[string]interface{}{"name": "Classroom", "language": "en", "words": words})
+	return string(b)
+}
+
+func TestParseTaggedWords(t *testing.T) {
+	var raw []json.RawMessage
+	err := json.Unmarshal([]byte(`["plain", {"word": "tagged", "difficulty": 2, "categories": [" Animals "], "rating": "family"}]`), &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	words, tags, err := parseTaggedWords(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalWords(words, []string{"plain", "tagged"}) || len(tags) != 2 {
+		t.Fatalf("parsed %q with %d tags", words, len(tags))
+	}
+	if tags[0].Difficulty != 0 || tags[1].Difficulty != 2 || tags[1].Rating != RatingFamily || tags[1].Categories[0] != "animals" {
+		t.Errorf("unexpected tags %+v", tags)
+	}
+
+	for _, bad := range []string{
+		`[{"word": "hard", "difficulty": 9}]`,
+		`[{"word": "rude", "rating": "pg-13"}]`,
+		`[{"word": "lost", "language": "not a language!"}]`,
+		`[42]`,
+	} {
+		json.Unmarshal([]byte(bad), &raw)
+		if _, _, err := parseTaggedWords(raw); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+
+	// Spellings with the same canonical form get the more conservative
+	// of their tags.
+	byWord, err := canonicalTags(
+		[]string{"Fox", "FOX", "fox"},
+		[]WordTags{{Difficulty: 1, Rating: RatingFamily, Categories: []string{"animals"}}, {Difficulty: 3}, {Categories: []string{"tv"}}},
+		"en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fox := byWord["FOX"]
+	if fox.Difficulty != 3 || fox.Rating != "" || !equalWords(fox.Categories, []string{"animals", "tv"}) {
+		t.Errorf("merged tags %+v", fox)
+	}
+}
+
+func TestFilteredBoards(t *testing.T) {
+	s := newTestServer(nil)
+	rec, info := uploadWordPack(t, s, "", "application/json", taggedPack(40))
+	if rec.Code != 200 {
+		t.Fatalf("uploading: status %d: %s", rec.Code, rec.Body)
+	}
+	if !info.Tagged {
+		t.Error("word pack isn't tagged")
+	}
+
+	testCases := []struct {
+		filters map[string]interface{}
+		allowed func(WordTags) bool
+	}{
+		{
+			filters: map[string]interface{}{"family_safe": true},
+			allowed: func(t WordTags) bool { return t.Rating == RatingFamily },
+		},
+		{
+			filters: map[string]interface{}{"max_difficulty": 2},
+			allowed: func(t WordTags) bool { return t.Difficulty == 1 || t.Difficulty == 2 },
+		},
+		{
+			filters: map[string]interface{}{"category": "LEVEL 2"},
+			allowed: func(t WordTags) bool { return t.Difficulty == 2 },
+		},
+	}
+	for i, tc := range testCases {
+		body := map[string]interface{}{
+			"game_id":   fmt.Sprintf("class-%d", i),
+			"word_sets": []string{info.Handle},
+		}
+		for k, v := range tc.filters {
+			body[k] = v
+		}
+		var g Game
+		if code := do(t, s.handleNextGame, "POST", body, &g); code != 200 {
+			t.Fatalf("%v: status %d", tc.filters, code)
+		}
+		if g.BoardCode != "" {
+			t.Errorf("%v: filtered board has a board code", tc.filters)
+		}
+
+		// Every board, across reshuffles, only has allowed words.
+		for game := 0; game < 5; game++ {
+			for _, w := range g.Words {
+				var tags WordTags
+				for j, ws := range g.WordSet {
+					if ws == w {
+						tags = g.Tags[j]
+					}
+				}
+				if !tc.allowed(tags) {
+					t.Errorf("%v: game %d has %q, tagged %+v", tc.filters, game, w, tags)
+				}
+			}
+			next := map[string]interface{}{
+				"game_id":    body["game_id"],
+				"create_new": true,
+			}
+			for k, v := range tc.filters {
+				next[k] = v
+			}
+			do(t, s.handleNextGame, "POST", next, &g)
+		}
+		if len(g.WordSet) != 121 {
+			t.Errorf("%v: game kept %d words of its word set, expected all 121", tc.filters, len(g.WordSet))
+		}
+	}
+
+	// Filters only apply to the games they're given for, and don't
+	// narrow the word set of later games.
+	body := map[string]interface{}{
+		"game_id":        "class-changing",
+		"word_sets":      []string{info.Handle},
+		"max_difficulty": 2,
+	}
+	if code := do(t, s.handleNextGame, "POST", body, nil); code != 200 {
+		t.Fatalf("status %d", code)
+	}
+	var g Game
+	seen := map[int]bool{}
+	for game := 0; game < 5; game++ {
+		do(t, s.handleNextGame, "POST", map[string]interface{}{
+			"game_id":    "class-changing",
+			"create_new": true,
+			"category":   "level 3",
+		}, &g)
+		for _, w := range g.Words {
+			for j, ws := range g.WordSet {
+				if ws == w {
+					seen[g.Tags[j].Difficulty] = true
+				}
+			}
+		}
+	}
+	if !seen[3] || seen[1] || seen[2] {
+		t.Errorf("boards filtered by category after a difficulty filter had difficulties %v, expected only 3", seen)
+	}
+
+	// Filters that leave too few words, or words without tags, are
+	// rejected rather than weakened.
+	_, small := uploadWordPack(t, s, "", "application/json", taggedPack(10))
+	for i, body := range []map[string]interface{}{
+		{"word_sets": []string{small.Handle}, "family_safe": true},
+		{"family_safe": true},
+		{"word_sets": []string{info.Handle}, "category": "cooking"},
+	} {
+		body["game_id"] = fmt.Sprintf("too-few-%d", i)
+		if code := do(t, s.handleNextGame, "POST", body, nil); code != 400 {
+			t.Errorf("%v: status %d, expected 400", body, code)
+		}
+	}
+
+	// A room's filters apply to its games.
+	code := do(t, s.handleRoom, "POST", map[string]interface{}{
+		"room_id":         "classroom",
+		"word_sets":       []string{info.Handle},
+		"default_options": GameOptions{FamilySafe: true},
+	}, nil)
+	if code != 200 {
+		t.Fatalf("creating room: status %d", code)
+	}
+	do(t, s.handleNextGame, "POST", map[string]interface{}{"game_id": "classroom", "timer_duration_ms": 60000}, &g)
+	if !g.FamilySafe {
+		t.Error("room's filters weren't applied")
+	}
+	for _, w := range g.Words {
+		for j, ws := range g.WordSet {
+			if ws == w && g.Tags[j].Rating != RatingFamily {
+				t.Errorf("room's game has %q, tagged %+v", w, g.Tags[j])
+			}
+		}
+	}
+	code = do(t, s.handleRoom, "POST", map[string]interface{}{
+		"room_id":         "playground",
+		"default_options": GameOptions{FamilySafe: true},
+	}, nil)
+	if code != 400 {
+		t.Errorf("room with filters and no word set: status %d, expected 400", code)
+	}
+}
+
+func TestLoadTaggedWordSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagged-word-sets-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	index := `[{"name": "Classroom", "language": "en", "file": "classroom.json"}]`
+	if err := ioutil.WriteFile(filepath.Join(dir, wordSetsIndex), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "classroom.json"), []byte(taggedPack(10)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var ws WordSets
+	if err := ws.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	infos := ws.List()
+	if len(infos) != 1 || infos[0].Size != 31 || !infos[0].Tagged {
+		t.Fatalf("unexpected word sets %+v", infos)
+	}
+	tags := ws.Tags([]string{"Classroom"})
+	if tags["WORD 2-3"].Difficulty != 2 || tags["UNTAGGED"].Difficulty != 0 {
+		t.Errorf("unexpected tags %+v", tags)
+	}
+}
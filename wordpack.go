@@ -0,0 +1,314 @@
+package codenames
+
+import (
+	"encoding/base32"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Limits on uploaded word packs.
+const (
+	maxWordPackBytes  = 1 << 20
+	maxWordPackWords  = 10000
+	maxWordPackName   = 64
+	maxWordPackLength = 40
+	// maxWordPacks and maxWordPacksBytes bound the word packs kept by
+	// the server, by their number and the total size of their words.
+	maxWordPacks      = 1000
+	maxWordPacksBytes = 64 << 20
+)
+
+var errWordPacksFull = errors.New("no more word packs can be uploaded")
+
+// handleLen is the number of bytes of a word pack's ID encoded in its
+// handle.
+const handleLen = 5
+
+var handleEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// WordPack is a word set uploaded by players, so that it can be reused
+// across rooms and games without resending it. Packs are identified by
+// their word set ID, and shared by a short handle derived from it.
+type WordPack struct {
+	ID       string   `json:"id"`
+	Handle   string   `json:"handle"`
+	Name     string   `json:"name"`
+	Language string   `json:"language,omitempty"`
+	Words    []string `json:"words"`
+	// Tags holds the tags of each of Words, if the pack is tagged.
+	Tags      []WordTags `json:"tags,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+
+	byWord map[string]WordTags
+}
+
+func newWordPack(id wordSetID, name, lang string, words []string, tags []WordTags, now time.Time) *WordPack {
+	return &WordPack{
+		ID:        id.String(),
+		Handle:    handleEncoding.EncodeToString(id[:handleLen]),
+		Name:      name,
+		Language:  lang,
+		Words:     words,
+		Tags:      tags,
+		CreatedAt: now,
+	}
+}
+
+func (p *WordPack) info() WordSetInfo {
+	return WordSetInfo{
+		Name:     p.Name,
+		Language: p.Language,
+		Size:     len(p.Words),
+		ID:       p.ID,
+		Handle:   p.Handle,
+		Tagged:   len(p.Tags) > 0,
+	}
+}
+
+// size returns the total size of the pack's words in bytes.
+func (p *WordPack) size() int {
+	var n int
+	for _, w := range p.Words {
+		n += len(w)
+	}
+	return n
+}
+
+// addPack makes p available by its handle. If a pack already has the
+// same handle, that pack is returned instead. It returns
+// errWordPacksFull if adding p would exceed the limits on word packs.
+func (ws *WordSets) addPack(p *WordPack) (*WordPack, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.init()
+	if existing, ok := ws.packs[p.Handle]; ok {
+		return existing, nil
+	}
+	size := p.size()
+	if len(ws.packs) >= maxWordPacks || ws.packBytes+size > maxWordPacksBytes {
+		return nil, errWordPacksFull
+	}
+	if len(p.Tags) == len(p.Words) {
+		for i, w := range p.Words {
+			if p.byWord == nil {
+				p.byWord = make(map[string]WordTags, len(p.Words))
+			}
+			p.byWord[w] = p.Tags[i]
+		}
+	}
+	var id wordSetID
+	if b, err := hex.DecodeString(p.ID); err == nil && len(b) == len(id) {
+		copy(id[:], b)
+		p.Words = ws.intern(id, p.Words)
+	}
+	ws.packs[p.Handle] = p
+	ws.packBytes += size
+	return p, nil
+}
+
+// Pack returns the word pack with the given handle.
+func (ws *WordSets) Pack(handle string) (*WordPack, bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	p, ok := ws.packs[handle]
+	return p, ok
+}
+
+// Word packs are stored under the []byte(`/packs/`) key prefix, keyed
+// by their word set ID.
+const (
+	packsPrefix     = "/packs/"
+	packsUpperBound = "/packs0"
+)
+
+func packKey(id string) []byte {
+	return []byte(packsPrefix + id)
+}
+
+// SaveWordPack saves the word pack to persistent storage.
+func (ps *PebbleStore) SaveWordPack(p *WordPack) error {
+	v, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshaling WordPack: %w", err)
+	}
+	err = ps.write(Change{Op: changeSet, Key: packKey(p.ID), Value: v})
+	if err != nil {
+		return fmt.Errorf("db.Set: %w", err)
+	}
+	return nil
+}
+
+// RestoreWordPacks loads all word packs from persistent storage.
+func (ps *PebbleStore) RestoreWordPacks() ([]*WordPack, error) {
+	iter := ps.DB.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(packsPrefix),
+		UpperBound: []byte(packsUpperBound),
+	})
+	defer iter.Close()
+
+	var packs []*WordPack
+	for _ = iter.First(); iter.Valid(); iter.Next() {
+		var p WordPack
+		if err := json.Unmarshal(iter.Value(), &p); err != nil {
+			log.Printf("Quarantining undecodable word pack %s: %s\n", iter.Key(), err)
+			if err := ps.quarantine(iter.Key(), iter.Value(), err); err != nil {
+				return nil, fmt.Errorf("quarantine: %w", err)
+			}
+			continue
+		}
+		packs = append(packs, &p)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("restore word packs iter: %w", err)
+	}
+	return packs, nil
+}
+
+// parseWordPack reads the words of an uploaded word pack from r, which
+// holds plain text with one word per line, CSV, or JSON, according to
+// the media type. JSON uploads may also carry the name and language of
+// the pack, and tag each word (see parseTaggedWords).
+func parseWordPack(r io.Reader, mediaType string) (words []string, tags []WordTags, name, lang string, err error) {
+	switch mediaType {
+	case "application/json":
+		var body struct {
+			Name     string            `json:"name"`
+			Language string            `json:"language"`
+			Words    []json.RawMessage `json:"words"`
+		}
+		if err := json.NewDecoder(r).Decode(&body); err != nil {
+			return nil, nil, "", "", errors.New("Error decoding")
+		}
+		words, tags, err = parseTaggedWords(body.Words)
+		if err != nil {
+			return nil, nil, "", "", err
+		}
+		name, lang = body.Name, body.Language
+	case "text/csv":
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		records, err := cr.ReadAll()
+		if err != nil {
+			return nil, nil, "", "", fmt.Errorf("invalid CSV: %w", err)
+		}
+		for _, record := range records {
+			words = append(words, record...)
+		}
+	case "", "text/plain":
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, nil, "", "", err
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			words = append(words, line)
+		}
+	default:
+		return nil, nil, "", "", fmt.Errorf("unsupported content type %q", mediaType)
+	}
+
+	if len(words) > maxWordPackWords {
+		return nil, nil, "", "", fmt.Errorf("word packs may hold at most %d words", maxWordPackWords)
+	}
+	for i, w := range words {
+		if strings.TrimSpace(w) == "" {
+			return nil, nil, "", "", fmt.Errorf("word %d is empty", i+1)
+		}
+	}
+	return words, tags, name, lang, nil
+}
+
+// POST /word-packs?name=<name>&language=<language>
+//
+// Uploads a word pack, returning its handle. The body is plain text with
+// one word per line, CSV, or JSON with name, language and words fields,
+// in which words may be tagged with their difficulty, categories, content
+// rating and language. Once the server holds as many packs as it keeps,
+// new packs are refused with 507 Insufficient Storage.
+//
+// GET /word-packs?handle=<handle>
+//
+// Returns a word pack, including its words.
+func (s *Server) handleWordPacks(rw http.ResponseWriter, req *http.Request) {
+	if req.Method == "GET" {
+		p, ok := s.wordSets.Pack(req.URL.Query().Get("handle"))
+		if !ok {
+			http.NotFound(rw, req)
+			return
+		}
+		writeJSON(rw, p)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	words, tags, name, lang, err := parseWordPack(http.MaxBytesReader(rw, req.Body, maxWordPackBytes), mediaType)
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	q := req.URL.Query()
+	if name == "" {
+		name = q.Get("name")
+	}
+	if lang == "" {
+		lang = q.Get("language")
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxWordPackName {
+		http.Error(rw, fmt.Sprintf("name must be between 1 and %d characters", maxWordPackName), 400)
+		return
+	}
+
+	byWord, err := canonicalTags(words, tags, lang)
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	id, words, err := s.wordSets.CanonicalizeLanguage(words, lang)
+	if err == nil && len(words) == 0 {
+		err = errors.New("need at least 25 words")
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	for _, w := range words {
+		if utf8.RuneCountInString(w) > maxWordPackLength {
+			http.Error(rw, fmt.Sprintf("%q is longer than %d characters", w, maxWordPackLength), 400)
+			return
+		}
+	}
+
+	p := newWordPack(id, name, lang, words, alignTags(words, byWord), s.Clock.Now())
+	added, err := s.wordSets.addPack(p)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	if added != p {
+		if added.ID != p.ID {
+			http.Error(rw, "a different word pack already has this handle", 409)
+			return
+		}
+		// The same words were uploaded before; share the existing pack.
+		writeJSON(rw, added.info())
+		return
+	}
+	if err := s.Store.SaveWordPack(p); err != nil {
+		log.Printf("Unable to write word pack %q to disk: %s\n", p.ID, err)
+	}
+	writeJSON(rw, p.info())
+}
@@ -0,0 +1,136 @@
+package codenames
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func uploadWordPack(t *testing.T, s *Server, query, contentType, body string) (*httptest.ResponseRecorder, WordSetInfo) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/word-packs?"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	s.handleWordPacks(rec, req)
+	var info WordSetInfo
+	if rec.Code == 200 {
+		if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec, info
+}
+
+func TestWordPacks(t *testing.T) {
+	ps := openTestStore(t, "test-word-packs-*")
+	defer ps.DB.Close()
+	s := newTestServer(ps)
+
+	var words []string
+	for i := 0; i < 30; i++ {
+		words = append(words, fmt.Sprintf("joke %d", i))
+	}
+
+	rec, text := uploadWordPack(t, s, "name=In-jokes", "text/plain; charset=utf-8", strings.Join(words, "\n")+"\n")
+	if rec.Code != 200 {
+		t.Fatalf("plain text upload: status %d: %s", rec.Code, rec.Body)
+	}
+	if text.Name != "In-jokes" || text.Size != 30 || len(text.Handle) != 8 {
+		t.Errorf("unexpected word pack: %+v", text)
+	}
+
+	// The same words in another format are the same pack.
+	_, csv := uploadWordPack(t, s, "name=Again", "text/csv", strings.Join(words[:15], ", ")+"\n"+strings.Join(words[15:], ","))
+	b, _ := json.Marshal(map[string]interface{}{"name": "Once more", "words": words})
+	_, js := uploadWordPack(t, s, "", "application/json", string(b))
+	if csv.Handle != text.Handle || js.Handle != text.Handle || js.Name != "In-jokes" {
+		t.Errorf("re-uploading a word pack produced %+v and %+v, expected %+v", csv, js, text)
+	}
+
+	for name, tc := range map[string]struct{ contentType, body string }{
+		"too few words": {"text/plain", strings.Join(words[:24], "\n")},
+		"empty entry":   {"text/csv", strings.Join(words, ",") + ",,"},
+		"too long":      {"text/plain", strings.Join(words, "\n") + "\n" + strings.Repeat("A", 41)},
+		"unknown type":  {"image/png", strings.Join(words, "\n")},
+	} {
+		if rec, _ := uploadWordPack(t, s, "name=bad", tc.contentType, tc.body); rec.Code != 400 {
+			t.Errorf("%s: status %d, expected 400", name, rec.Code)
+		}
+	}
+	if rec, _ := uploadWordPack(t, s, "", "text/plain", strings.Join(words, "\n")); rec.Code != 400 {
+		t.Errorf("missing name: status %d, expected 400", rec.Code)
+	}
+
+	// Other rooms can play with the pack by its handle.
+	var g Game
+	code := do(t, s.handleNextGame, "POST", map[string]interface{}{
+		"game_id":   "in-jokes",
+		"word_sets": []string{text.Handle},
+	}, &g)
+	if code != 200 || len(g.WordSet) != 30 || g.WordSet[0] != "JOKE 0" {
+		t.Fatalf("creating a game from a word pack: status %d, words %v", code, g.WordSet)
+	}
+
+	// The pack survives a restart.
+	packs, err := ps.RestoreWordPacks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packs) != 1 || packs[0].Handle != text.Handle || len(packs[0].Words) != 30 {
+		t.Fatalf("restored word packs %+v", packs)
+	}
+	restarted := newTestServer(ps)
+	if _, err := restarted.wordSets.addPack(packs[0]); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/word-packs?handle="+text.Handle, nil)
+	rec = httptest.NewRecorder()
+	restarted.handleWordPacks(rec, req)
+	var p WordPack
+	decodeRecorder(t, rec, &p)
+	if p.Name != "In-jokes" || len(p.Words) != 30 {
+		t.Errorf("restored word pack %+v", p)
+	}
+}
+
+func TestWordPackLimits(t *testing.T) {
+	s := newTestServer(nil)
+	var words []string
+	for i := 0; i < 30; i++ {
+		words = append(words, fmt.Sprintf("joke %d", i))
+	}
+	upload := func(i int) int {
+		rec, _ := uploadWordPack(t, s, "name=In-jokes", "text/plain", fmt.Sprintf("%s\nextra %d", strings.Join(words, "\n"), i))
+		return rec.Code
+	}
+
+	// Packs are limited by the total size of their words...
+	s.wordSets.packBytes = maxWordPacksBytes - 100
+	if code := upload(0); code != 507 {
+		t.Errorf("uploading beyond the size limit: status %d, expected 507", code)
+	}
+	s.wordSets.packBytes = 0
+	if code := upload(0); code != 200 {
+		t.Fatalf("uploading a word pack: status %d", code)
+	}
+
+	// ...and by their number.
+	for i := 1; i < maxWordPacks; i++ {
+		var id wordSetID
+		binary.BigEndian.PutUint32(id[:], uint32(i))
+		if _, err := s.wordSets.addPack(newWordPack(id, "filler", "", words, nil, time.Now())); err != nil {
+			t.Fatalf("adding pack %d: %s", i, err)
+		}
+	}
+	if code := upload(1); code != 507 {
+		t.Errorf("uploading beyond the number of packs: status %d, expected 507", code)
+	}
+	// Packs that were already uploaded are still shared.
+	if code := upload(0); code != 200 {
+		t.Errorf("re-uploading an existing pack: status %d", code)
+	}
+}
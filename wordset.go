@@ -1,10 +1,14 @@
 package codenames
 
 import (
+	"bytes"
 	"crypto/sha1"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
@@ -16,36 +20,81 @@ func (i wordSetID) String() string {
 	return fmt.Sprintf("%x", i[:])
 }
 
+// maxCustomWordSets is the number of custom word sets, posted with
+// games rather than named or uploaded, that are remembered so that
+// their board codes can be resolved.
+const maxCustomWordSets = 1000
+
 type WordSets struct {
-	mu   sync.Mutex
-	byID map[wordSetID][]string
+	mu    sync.Mutex
+	byID  map[wordSetID][]string // named word sets and word packs
+	named map[string]namedWordSet
+	packs map[string]*WordPack // by handle
+	// packBytes is the total size of the words of packs.
+	packBytes int
+
+	// custom holds the most recently remembered custom word sets, and
+	// customOrder their IDs from least to most recently remembered.
+	custom      map[wordSetID][]string
+	customOrder []wordSetID
+	// byPrefix indexes every known word set by the prefix of its ID
+	// included in board codes.
+	byPrefix map[[boardCodeIDLen]byte]wordSetID
+}
+
+// WordSetInfo describes a named word set, such as a language pack.
+type WordSetInfo struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Size     int    `json:"size"`
+	ID       string `json:"id"`
+	Handle   string `json:"handle,omitempty"`
+	Tagged   bool   `json:"tagged,omitempty"`
+}
+
+type namedWordSet struct {
+	info  WordSetInfo
+	words []string
+	tags  map[string]WordTags
 }
 
 func (ws *WordSets) init() {
 	if ws.byID == nil {
 		ws.byID = make(map[wordSetID][]string)
 	}
+	if ws.named == nil {
+		ws.named = make(map[string]namedWordSet)
+	}
+	if ws.packs == nil {
+		ws.packs = make(map[string]*WordPack)
+	}
+	if ws.custom == nil {
+		ws.custom = make(map[wordSetID][]string)
+	}
+	if ws.byPrefix == nil {
+		ws.byPrefix = make(map[[boardCodeIDLen]byte]wordSetID)
+	}
 }
 
+// Canonicalize canonicalizes words using language-neutral case mapping,
+// and returns the word set along with its ID.
 func (ws *WordSets) Canonicalize(words []string) (wordSetID, []string, error) {
-	ws.mu.Lock()
-	defer ws.mu.Unlock()
-	ws.init()
+	return ws.CanonicalizeLanguage(words, "")
+}
 
-	set := map[string]bool{}
-	for _, w := range words {
-		set[strings.TrimSpace(strings.ToUpper(w))] = true
+// CanonicalizeLanguage canonicalizes words using the case mapping of the
+// language with the BCP 47 tag lang, and returns the word set along with
+// its ID. If the word set is known, its interned copy is returned, but
+// unknown word sets aren't stored.
+func (ws *WordSets) CanonicalizeLanguage(words []string, lang string) (wordSetID, []string, error) {
+	words, err := canonicalWords(words, lang)
+	if err != nil {
+		return wordSetID{}, nil, err
 	}
-	if len(set) > 0 && len(set) < 25 {
+	if len(words) > 0 && len(words) < 25 {
 		return wordSetID{}, nil, errors.New("need at least 25 words")
 	}
 
-	words = words[:0]
-	for w := range set {
-		words = append(words, w)
-	}
-	sort.Strings(words)
-
 	// Calculate the word set ID, a hash of the canonicalized word set.
 	h := sha1.New()
 	for _, w := range words {
@@ -56,9 +105,240 @@ func (ws *WordSets) Canonicalize(words []string) (wordSetID, []string, error) {
 	var id wordSetID
 	copy(id[:], idBytes)
 
-	if interned, ok := ws.byID[id]; ok {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if interned, ok := ws.known(id); ok {
 		return id, interned, nil
 	}
-	ws.byID[id] = words
 	return id, words, nil
 }
+
+// known returns the interned copy of the word set with the given ID, if
+// it's a named word set, a word pack or a remembered custom word set.
+// ws.mu must be held.
+func (ws *WordSets) known(id wordSetID) ([]string, bool) {
+	if words, ok := ws.byID[id]; ok {
+		return words, true
+	}
+	words, ok := ws.custom[id]
+	return words, ok
+}
+
+// intern stores the words of a named word set or word pack, which are
+// kept for as long as the server runs. ws.mu must be held.
+func (ws *WordSets) intern(id wordSetID, words []string) []string {
+	ws.init()
+	if interned, ok := ws.byID[id]; ok {
+		return interned
+	}
+	if custom, ok := ws.custom[id]; ok {
+		// The remembered copy becomes the interned one.
+		words = custom
+		delete(ws.custom, id)
+	}
+	ws.byID[id] = words
+	ws.byPrefix[idPrefix(id)] = id
+	return words
+}
+
+// remember stores a canonical custom word set with the given ID, so that
+// board codes for it can be resolved, and returns its interned copy. Only
+// the maxCustomWordSets most recently remembered word sets are kept.
+func (ws *WordSets) remember(id wordSetID, words []string) []string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.init()
+	if interned, ok := ws.known(id); ok {
+		return interned
+	}
+	ws.custom[id] = words
+	ws.customOrder = append(ws.customOrder, id)
+	p := idPrefix(id)
+	if _, ok := ws.byPrefix[p]; !ok {
+		ws.byPrefix[p] = id
+	}
+	for len(ws.customOrder) > maxCustomWordSets {
+		evicted := ws.customOrder[0]
+		ws.customOrder = ws.customOrder[1:]
+		if _, ok := ws.custom[evicted]; !ok {
+			// It has since been interned.
+			continue
+		}
+		delete(ws.custom, evicted)
+		if p := idPrefix(evicted); ws.byPrefix[p] == evicted {
+			delete(ws.byPrefix, p)
+		}
+	}
+	return words
+}
+
+func idPrefix(id wordSetID) [boardCodeIDLen]byte {
+	var p [boardCodeIDLen]byte
+	copy(p[:], id[:])
+	return p
+}
+
+// lookupPrefix returns the known word set whose ID begins with prefix,
+// if any.
+func (ws *WordSets) lookupPrefix(prefix [boardCodeIDLen]byte) ([]string, bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	id, ok := ws.byPrefix[prefix]
+	if !ok {
+		return nil, false
+	}
+	return ws.known(id)
+}
+
+// wordSetsIndex is the name of the file describing the word sets in a
+// word set directory.
+const wordSetsIndex = "wordsets.json"
+
+// LoadDir loads the named word sets described by the index file in dir.
+// Each word set is read from its own file of newline-separated words, or
+// of tagged words in the JSON word pack format, and interned.
+func (ws *WordSets) LoadDir(dir string) error {
+	b, err := ioutil.ReadFile(filepath.Join(dir, wordSetsIndex))
+	if err != nil {
+		return err
+	}
+	var index []struct {
+		Name     string `json:"name"`
+		Language string `json:"language"`
+		File     string `json:"file"`
+	}
+	if err := json.Unmarshal(b, &index); err != nil {
+		return fmt.Errorf("%s: %w", wordSetsIndex, err)
+	}
+
+	for _, entry := range index {
+		if entry.Name == "" || entry.File != filepath.Base(entry.File) {
+			return fmt.Errorf("%s: invalid entry %q", wordSetsIndex, entry.Name)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, entry.File))
+		if err != nil {
+			return err
+		}
+		var words []string
+		var tags []WordTags
+		if filepath.Ext(entry.File) == ".json" {
+			words, tags, _, _, err = parseWordPack(bytes.NewReader(b), "application/json")
+			if err != nil {
+				return fmt.Errorf("word set %q: %w", entry.Name, err)
+			}
+		} else {
+			for _, line := range strings.Split(string(b), "\n") {
+				if w := strings.TrimSpace(line); w != "" {
+					words = append(words, w)
+				}
+			}
+		}
+		byWord, err := canonicalTags(words, tags, entry.Language)
+		if err != nil {
+			return fmt.Errorf("word set %q: %w", entry.Name, err)
+		}
+		id, words, err := ws.CanonicalizeLanguage(words, entry.Language)
+		if err != nil {
+			return fmt.Errorf("word set %q: %w", entry.Name, err)
+		}
+
+		ws.mu.Lock()
+		words = ws.intern(id, words)
+		ws.named[entry.Name] = namedWordSet{
+			info: WordSetInfo{
+				Name:     entry.Name,
+				Language: entry.Language,
+				Size:     len(words),
+				ID:       id.String(),
+				Tagged:   byWord != nil,
+			},
+			words: words,
+			tags:  byWord,
+		}
+		ws.mu.Unlock()
+	}
+	return nil
+}
+
+// List returns a description of every named word set, sorted by name.
+func (ws *WordSets) List() []WordSetInfo {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	infos := make([]WordSetInfo, 0, len(ws.named))
+	for _, n := range ws.named {
+		infos = append(infos, n.info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Named returns the combined words of the named word sets. Word packs
+// may be included by their handles. The words of a single word set are
+// returned interned.
+func (ws *WordSets) Named(names []string) ([]string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	var words []string
+	for i, name := range names {
+		info, ok := ws.lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown word set %q", name)
+		}
+		if i == 0 {
+			words = info.words
+		} else {
+			words = append(words[:len(words):len(words)], info.words...)
+		}
+	}
+	return words, nil
+}
+
+// Tags returns the tags of the words of the named word sets, keyed by
+// word, or nil if none of them are tagged. A word in more than one of the
+// word sets has the tags of the first that tags it.
+func (ws *WordSets) Tags(names []string) map[string]WordTags {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	var tags map[string]WordTags
+	for _, name := range names {
+		n, _ := ws.lookup(name)
+		for w, t := range n.tags {
+			if tags == nil {
+				tags = map[string]WordTags{}
+			}
+			if _, ok := tags[w]; !ok {
+				tags[w] = t
+			}
+		}
+	}
+	return tags
+}
+
+// lookup returns the word set with the given name, or the word pack
+// with the given handle. ws.mu must be held.
+func (ws *WordSets) lookup(name string) (namedWordSet, bool) {
+	if n, ok := ws.named[name]; ok {
+		return n, true
+	}
+	if p, ok := ws.packs[name]; ok {
+		return namedWordSet{info: p.info(), words: p.Words, tags: p.byWord}, true
+	}
+	return namedWordSet{}, false
+}
+
+// Language returns the language of the named word sets, or an empty
+// string if they don't share one.
+func (ws *WordSets) Language(names []string) string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	var lang string
+	for i, name := range names {
+		n, _ := ws.lookup(name)
+		l := n.info.Language
+		if i > 0 && l != lang {
+			return ""
+		}
+		lang = l
+	}
+	return lang
+}
@@ -1,33 +1,34 @@
 package codenames
 
 import (
-	"bytes"
-	"encoding/json"
-	"io/ioutil"
+	"fmt"
 	"testing"
 )
 
 func TestWordSetCanonicalize(t *testing.T) {
-	b, err := ioutil.ReadFile("frontend/words.json")
-	if err != nil {
+	var loaded WordSets
+	if err := loaded.LoadDir("assets/wordsets"); err != nil {
 		t.Fatal(err)
 	}
-	var defaultWordsets map[string][]string
-	err = json.NewDecoder(bytes.NewReader(b)).Decode(&defaultWordsets)
-	if err != nil {
-		t.Fatal(err)
+	defaultWordsets := map[string][]string{}
+	for _, info := range loaded.List() {
+		words, err := loaded.Named([]string{info.Name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defaultWordsets[info.Name] = append([]string(nil), words...)
 	}
 
 	internedSets := map[string][]string{}
 
 	var ws WordSets
 	for name, words := range defaultWordsets {
-		id, interned, err := ws.Canonicalize(words)
+		id, canonical, err := ws.Canonicalize(words)
 		if err != nil {
 			t.Fatal(err)
 		}
 		t.Logf("%s : %s\n", name, id)
-		internedSets[name] = interned
+		internedSets[name] = ws.remember(id, canonical)
 	}
 
 	for name, words := range defaultWordsets {
@@ -41,3 +42,146 @@ func TestWordSetCanonicalize(t *testing.T) {
 		}
 	}
 }
+
+func TestCustomWordSetsBounded(t *testing.T) {
+	var ws WordSets
+	customSet := func(i int) []string {
+		words := make([]string, wordsPerGame)
+		for j := range words {
+			words[j] = fmt.Sprintf("WORD%d-%02d", i, j)
+		}
+		return words
+	}
+
+	// Canonicalizing a word set doesn't store it.
+	id, words, err := ws.Canonicalize(customSet(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ws.lookupPrefix(idPrefix(id)); ok || len(ws.custom) != 0 {
+		t.Fatal("canonicalizing stored a custom word set")
+	}
+
+	first := ws.remember(id, words)
+	if found, ok := ws.lookupPrefix(idPrefix(id)); !ok || &found[0] != &first[0] {
+		t.Fatal("remembered word set can't be found by prefix")
+	}
+	var last wordSetID
+	for i := 1; i <= maxCustomWordSets; i++ {
+		last, words, _ = ws.Canonicalize(customSet(i))
+		ws.remember(last, words)
+	}
+	if len(ws.custom) != maxCustomWordSets || len(ws.byPrefix) != maxCustomWordSets {
+		t.Errorf("remembering %d word sets kept %d, indexed %d", maxCustomWordSets+1, len(ws.custom), len(ws.byPrefix))
+	}
+	if _, ok := ws.lookupPrefix(idPrefix(id)); ok {
+		t.Error("least recently remembered word set wasn't forgotten")
+	}
+	if _, ok := ws.lookupPrefix(idPrefix(last)); !ok {
+		t.Error("most recently remembered word set was forgotten")
+	}
+}
+
+func TestNamedWordSets(t *testing.T) {
+	var ws WordSets
+	if err := ws.LoadDir("assets/wordsets"); err != nil {
+		t.Fatal(err)
+	}
+	infos := ws.List()
+	if len(infos) < 2 {
+		t.Fatalf("loaded %d word sets", len(infos))
+	}
+	languages := map[string]string{}
+	for _, info := range infos {
+		if info.Size < wordsPerGame {
+			t.Errorf("word set %q has only %d words", info.Name, info.Size)
+		}
+		languages[info.Name] = info.Language
+	}
+	if languages["French"] != "fr" || languages["Hebrew"] != "he" {
+		t.Errorf("unexpected languages: %v", languages)
+	}
+
+	// A single word set is returned interned.
+	french, err := ws.Named([]string{"French"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, interned, err := ws.Canonicalize(french)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &interned[0] != &french[0] {
+		t.Error("named word set wasn't interned")
+	}
+
+	// Combining word sets mustn't modify either of them.
+	combined, err := ws.Named([]string{"French", "Italian"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	italian, _ := ws.Named([]string{"Italian"})
+	if len(combined) != len(french)+len(italian) {
+		t.Errorf("combined word set has %d words, expected %d", len(combined), len(french)+len(italian))
+	}
+	if french, _ := ws.Named([]string{"French"}); len(french) != len(interned) {
+		t.Error("combining word sets modified the first")
+	}
+
+	if _, err := ws.Named([]string{"Klingon"}); err == nil {
+		t.Error("expected an error for an unknown word set")
+	}
+}
+
+func TestCanonicalWords(t *testing.T) {
+	testCases := []struct {
+		lang  string
+		words []string
+		want  []string
+	}{
+		// Turkish has a dotted and a dotless i.
+		{lang: "tr", words: []string{"istanbul", "ılık"}, want: []string{"ILIK", "İSTANBUL"}},
+		{lang: "", words: []string{"istanbul"}, want: []string{"ISTANBUL"}},
+		{lang: "de", words: []string{"Straße", "STRASSE"}, want: []string{"STRASSE"}},
+		// Final and medial sigma upper-case to the same letter.
+		{lang: "el", words: []string{"ήλιος", "ΗΛΙΟΣ", "ηλιοσ"}, want: []string{"ΗΛΙΟΣ"}},
+		// Precomposed and combining accents are the same word.
+		{lang: "fr", words: []string{"acn\u00e9", "acne\u0301"}, want: []string{"ACN\u00c9"}},
+		// Direction marks and zero-width spaces are invisible.
+		{lang: "he", words: []string{"\u200fאביב", "אביב\u200e", "א\u200bביב"}, want: []string{"אביב"}},
+		{lang: "en", words: []string{"\ufeffsoft", "so\u00adft"}, want: []string{"SOFT"}},
+		// Zero-width non-joiners and joiners change how words are written.
+		{lang: "fa", words: []string{"می\u200cخواهم", "میخواهم"}, want: []string{"میخواهم", "می\u200cخواهم"}},
+		{lang: "hi", words: []string{"क्\u200dष"}, want: []string{"क्\u200dष"}},
+		{lang: "en", words: []string{"  ice \t cream ", "ICE CREAM", "", "\u200b"}, want: []string{"ICE CREAM"}},
+	}
+	for _, tc := range testCases {
+		got, err := canonicalWords(tc.words, tc.lang)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalWords(got, tc.want) {
+			t.Errorf("canonicalWords(%q, %q) = %q, expected %q", tc.words, tc.lang, got, tc.want)
+		}
+	}
+
+	if _, err := canonicalWords([]string{"word"}, "not a language!"); err == nil {
+		t.Error("expected an error for an invalid language")
+	}
+
+	// Canonical forms must be stable, so that word set IDs are too.
+	var ws WordSets
+	if err := ws.LoadDir("assets/wordsets"); err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range ws.List() {
+		words, _ := ws.Named([]string{info.Name})
+		again, err := canonicalWords(words, info.Language)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalWords(again, words) {
+			t.Errorf("word set %q isn't stable under canonicalization", info.Name)
+		}
+	}
+}